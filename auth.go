@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The environment variables to give the credentials, which must not be given by the command line arguments
+const (
+	passwordEnv    = "ELASTICSEARCH_PASSWORD"
+	apiKeyEnv      = "ELASTICSEARCH_API_KEY"
+	bearerTokenEnv = "ELASTICSEARCH_BEARER_TOKEN"
+)
+
+// ReadSecret reads the secret from the file if the path is given, or from the environment variable.
+// The trailing newline of the file is trimmed.
+func ReadSecret(path string, env string) (string, error) {
+	if path == "" {
+		return os.Getenv(env), nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// authTransport sets the Authorization header to every request, for the schemes which the client does not support.
+type authTransport struct {
+	base          http.RoundTripper
+	authorization string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The request must not be modified by the transport, so the header is set to its copy
+	authReq := new(http.Request)
+	*authReq = *req
+	authReq.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		authReq.Header[key] = values
+	}
+	authReq.Header.Set("Authorization", t.authorization)
+	return t.base.RoundTrip(authReq)
+}
+
+// RedactURL hides the password in the URL, so that it can be logged or shown by df(1).
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	_, ok := u.User.Password()
+	if !ok {
+		return rawURL
+	}
+	u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return u.String()
+}
+
+// RedactURLs hides the passwords in the URLs.
+func RedactURLs(rawURLs []string) []string {
+	redacted := make([]string, len(rawURLs))
+	for i, rawURL := range rawURLs {
+		redacted[i] = RedactURL(rawURL)
+	}
+	return redacted
+}
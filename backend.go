@@ -0,0 +1,81 @@
+package main
+
+import "time"
+
+// Backend is the client of the cluster, which hides the differences of the APIs between the versions.
+type Backend interface {
+	GetIndexNames() ([]string, error)
+	GetIndexStats(index string) (IndexStats, error)
+	GetIndexMetadata(index string) (IndexMetadata, error)
+	GetDocumentTypes(index string) ([]string, error)
+	CountDocuments(index string, dtype string) (int64, error)
+	CountMatchingDocuments(index string, query []byte) (int64, error)
+	GetDocuments(index string, dtype string, query []byte, sort []byte, from int, size int) (map[string]Document, error)
+	GetPagedDocuments(index string, dtype string, from int, searchAfter []interface{}, size int, fetchSource bool) (map[string]Document, []interface{}, error)
+	IndexDocument(index string, dtype string, id string, routing string, source []byte) (DocumentMeta, error)
+	UpdateDocument(index string, dtype string, id string, routing string, partial []byte) (Document, error)
+	DeleteDocument(index string, dtype string, id string, routing string) error
+	DeleteIndex(index string) error
+	CreateIndex(index string, body []byte) error
+	PutMapping(index string, dtype string, mapping []byte) error
+	PutSettings(index string, settings []byte) error
+	UpdateAliases(actions []byte) error
+}
+
+// DocumentScroller is the backend which pages the documents beyond maxResultWindow by scrolling, instead of searching after the cursors.
+type DocumentScroller interface {
+	ScrollDocuments(index string, dtype string, from int, size int) (map[string]Document, error)
+}
+
+// PointInTimePager is the backend which pages the documents in a point in time, because they have no unique fields to sort by.
+// The documents are ordered stably in the point in time, so the cursors returned in it are valid only in it.
+type PointInTimePager interface {
+	// OpenPointInTime returns the ID of a new point in time of the index, or an empty ID if the cluster cannot page in it.
+	OpenPointInTime(index string, keepAlive time.Duration) (string, error)
+	// GetPointInTimeDocuments is GetPagedDocuments in the point in time, and returns its ID renewed by the search as well.
+	GetPointInTimeDocuments(pit string, keepAlive time.Duration, from int, searchAfter []interface{}, size int, fetchSource bool) (map[string]Document, []interface{}, string, error)
+}
+
+// The first major version of Elasticsearch whose indices have no document types
+const typelessMajorVersion = 7
+
+// The distribution of OpenSearch, which is forked from Elasticsearch 7 and has no document types since its first version
+const openSearchDistribution = "opensearch"
+
+// IsTypeless reports whether the indices of the cluster have no document types.
+// OpenSearch may pretend to be Elasticsearch 7.10.2 for the compatibility with the clients, but it tells its distribution anyway.
+func (v ClusterVersion) IsTypeless() bool {
+	if v.Distribution == openSearchDistribution {
+		return true
+	}
+	return v.MajorVersion() >= typelessMajorVersion
+}
+
+// The first version of Elasticsearch which sorts the documents in a point in time by _shard_doc
+const shardDocMajorVersion, shardDocMinorVersion = 7, 12
+
+// HasShardDocSort reports whether the documents of the typeless indices can be paged in a point in time, sorted by _shard_doc which is unique in it.
+// OpenSearch has its own API of the point in time without _shard_doc.
+func (v ClusterVersion) HasShardDocSort() bool {
+	if v.Distribution == openSearchDistribution {
+		return false
+	}
+	major := v.MajorVersion()
+	return major > shardDocMajorVersion || major == shardDocMajorVersion && v.MinorVersion() >= shardDocMinorVersion
+}
+
+// NewBackend connects to the cluster, and returns the backend for its version.
+func NewBackend(config ClientConfig) (Backend, error) {
+	client, err := NewElasticsearchClient(config)
+	if err != nil {
+		return nil, err
+	}
+	version, err := client.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version.IsTypeless() {
+		return NewTypelessClient(client, version.HasShardDocSort()), nil
+	}
+	return client, nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openSearchStandIn emulates the responses of OpenSearch for an index, whose documents are in the order of their IDs.
+type openSearchStandIn struct {
+	version string
+	index   string
+
+	mu      sync.Mutex
+	docs    map[string]json.RawMessage
+	scrolls map[string]int // The offsets of the next batches by the scroll IDs
+}
+
+func newOpenSearchStandIn(version string, index string, count int) *openSearchStandIn {
+	s := &openSearchStandIn{version: version, index: index, docs: make(map[string]json.RawMessage), scrolls: make(map[string]int)}
+	for i := 0; i < count; i++ {
+		s.docs[fmt.Sprintf("%05d", i)] = json.RawMessage(fmt.Sprintf(`{"n":%d}`, i))
+	}
+	return s
+}
+
+func (s *openSearchStandIn) write(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *openSearchStandIn) writeError(w http.ResponseWriter, status int, errorType string, reason string) {
+	s.write(w, status, map[string]interface{}{"error": map[string]interface{}{"type": errorType, "reason": reason}, "status": status})
+}
+
+// hits returns the hits of the documents in the order of their IDs from the offset.
+func (s *openSearchStandIn) hits(ids []string, from int, size int, fetchSource bool) []interface{} {
+	hits := []interface{}{}
+	for i := from; i < len(ids) && i < from+size; i++ {
+		hit := map[string]interface{}{"_index": s.index, "_id": ids[i], "_score": nil, "_version": 1, "_seq_no": i, "_primary_term": 1, "sort": []interface{}{i}}
+		if fetchSource {
+			hit["_source"] = s.docs[ids[i]]
+		}
+		hits = append(hits, hit)
+	}
+	return hits
+}
+
+func (s *openSearchStandIn) sortedIDs() []string {
+	ids := make([]string, 0, len(s.docs))
+	for id := range s.docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *openSearchStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, _ := ioutil.ReadAll(r.Body)
+	var body struct {
+		From        int             `json:"from"`
+		Size        *int            `json:"size"`
+		Sort        json.RawMessage `json:"sort"`
+		Source      *bool           `json:"_source"`
+		SearchAfter []interface{}   `json:"search_after"`
+		ScrollID    string          `json:"scroll_id"`
+		Query       struct {
+			IDs struct {
+				Values []string `json:"values"`
+			} `json:"ids"`
+		} `json:"query"`
+	}
+	json.Unmarshal(data, &body)
+	size := 10
+	if body.Size != nil {
+		size = *body.Size
+	}
+	fetchSource := body.Source == nil || *body.Source
+	indexPath := "/" + s.index
+
+	switch {
+	case r.URL.Path == "/":
+		s.write(w, http.StatusOK, map[string]interface{}{
+			"name":         "node-1",
+			"cluster_name": "opensearch",
+			"version": map[string]interface{}{
+				"distribution":                        "opensearch",
+				"number":                              s.version,
+				"minimum_wire_compatibility_version":  "6.8.0",
+				"minimum_index_compatibility_version": "6.0.0-beta1",
+			},
+			"tagline": "The OpenSearch Project: https://opensearch.org/",
+		})
+	case r.URL.Path == "/_all/_settings":
+		s.write(w, http.StatusOK, map[string]interface{}{s.index: map[string]interface{}{"settings": map[string]interface{}{}}})
+	case r.URL.Path == indexPath+"/_count":
+		s.write(w, http.StatusOK, map[string]interface{}{"count": len(s.docs)})
+	case r.URL.Path == indexPath+"/_search" && len(body.Query.IDs.Values) > 0:
+		var hits []interface{}
+		for _, id := range body.Query.IDs.Values {
+			_, ok := s.docs[id]
+			if ok {
+				hits = append(hits, map[string]interface{}{"_index": s.index, "_id": id, "_version": 1, "_seq_no": 0, "_primary_term": 1, "_source": s.docs[id]})
+			}
+		}
+		s.write(w, http.StatusOK, map[string]interface{}{"hits": map[string]interface{}{"total": len(hits), "hits": hits}})
+	case r.URL.Path == indexPath+"/_search":
+		// The stand-in rejects what OpenSearch and Elasticsearch 8 reject by default
+		if strings.Contains(string(body.Sort), `"_id"`) {
+			s.writeError(w, http.StatusBadRequest, "illegal_argument_exception", "Fielddata access on the _id field is disallowed")
+			return
+		}
+		if body.From+size > maxResultWindow {
+			s.writeError(w, http.StatusBadRequest, "illegal_argument_exception", "Result window is too large")
+			return
+		}
+		if body.SearchAfter != nil {
+			s.writeError(w, http.StatusBadRequest, "illegal_argument_exception", "search_after without a sort by unique values")
+			return
+		}
+		ids := s.sortedIDs()
+		result := map[string]interface{}{"hits": map[string]interface{}{"total": len(ids), "hits": s.hits(ids, body.From, size, fetchSource)}}
+		if r.URL.Query().Get("scroll") != "" {
+			scrollID := strconv.Itoa(len(s.scrolls))
+			s.scrolls[scrollID] = size
+			result["_scroll_id"] = scrollID
+		}
+		s.write(w, http.StatusOK, result)
+	case r.URL.Path == "/_search/scroll" && r.Method == "DELETE":
+		s.write(w, http.StatusOK, map[string]interface{}{"succeeded": true})
+	case r.URL.Path == "/_search/scroll":
+		offset, ok := s.scrolls[body.ScrollID]
+		if !ok {
+			s.writeError(w, http.StatusNotFound, "search_context_missing_exception", "No search context found")
+			return
+		}
+		s.scrolls[body.ScrollID] = offset + maxResultWindow
+		ids := s.sortedIDs()
+		s.write(w, http.StatusOK, map[string]interface{}{"_scroll_id": body.ScrollID, "hits": map[string]interface{}{"total": len(ids), "hits": s.hits(ids, offset, maxResultWindow, false)}})
+	case strings.HasPrefix(r.URL.Path, indexPath+"/_doc/") && r.Method == "PUT":
+		id := strings.TrimPrefix(r.URL.Path, indexPath+"/_doc/")
+		s.docs[id] = json.RawMessage(data)
+		s.write(w, http.StatusCreated, map[string]interface{}{"_index": s.index, "_id": id, "_version": 1, "result": "created", "_seq_no": len(s.docs), "_primary_term": 1})
+	default:
+		s.writeError(w, http.StatusNotFound, "resource_not_found_exception", "no handler for "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func TestNewBackendOpenSearch(t *testing.T) {
+	// OpenSearch 1.x may report the version of Elasticsearch it is forked from, and 2.x reports its own version
+	for _, version := range []string{"1.3.13", "7.10.2", "2.11.0"} {
+		server := httptest.NewServer(newOpenSearchStandIn(version, "idx", 0))
+		backend, err := NewBackend(ClientConfig{URLs: []string{server.URL}})
+		server.Close()
+		if err != nil {
+			t.Fatalf("version=%v, err=%v", version, err)
+		}
+		_, ok := backend.(*TypelessClient)
+		if !ok {
+			t.Errorf("OpenSearch is not connected by the typeless backend: version=%v, backend=%T", version, backend)
+		}
+	}
+}
+
+func TestOpenSearchBackend(t *testing.T) {
+	standIn := newOpenSearchStandIn("2.11.0", "idx", maxResultWindow+25)
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	backend, err := NewBackend(ClientConfig{URLs: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// List the index and its pseudo document type
+	indices, err := backend.GetIndexNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indices, []string{"idx"}) {
+		t.Errorf("unexpected indices: %v", indices)
+	}
+	dtypes, err := backend.GetDocumentTypes("idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dtypes, []string{typelessDocType}) {
+		t.Errorf("unexpected document types: %v", dtypes)
+	}
+	total, err := backend.CountDocuments("idx", typelessDocType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != maxResultWindow+25 {
+		t.Errorf("unexpected total: %v", total)
+	}
+
+	// Page the documents within the result window and beyond it
+	cache := &ElasticsearchCache{db: backend, pageSize: 10, updateInterval: time.Minute}
+	for _, page := range []int{0, 1, maxResultWindow/10 + 1, maxResultWindow/10 + 2, maxResultWindow/10 + 3} {
+		docs, err := cache.EnsureDocuments("idx", typelessDocType, page)
+		if err != nil {
+			t.Fatalf("page=%v, err=%v", page, err)
+		}
+		var ids []string
+		for id := range docs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		var expected []string
+		for i := page * 10; i < page*10+10 && i < maxResultWindow+25; i++ {
+			expected = append(expected, fmt.Sprintf("%05d", i))
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("unexpected documents in the page: page=%v, expected=%v, actual=%v", page, expected, ids)
+		}
+		for _, id := range expected {
+			if string(docs[id].Source) != string(standIn.docs[id]) {
+				t.Errorf("unexpected source: id=%v, source=%s", id, docs[id].Source)
+			}
+		}
+	}
+
+	// Index a new document
+	meta, err := backend.IndexDocument("idx", typelessDocType, "new", "", []byte(`{"n":-1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != "new" || meta.SeqNo == nil {
+		t.Errorf("unexpected metadata of the indexed document: %+v", meta)
+	}
+	if string(standIn.docs["new"]) != `{"n":-1}` {
+		t.Errorf("the document is not indexed: %s", standIn.docs["new"])
+	}
+}
+
+// pointInTimeStandIn emulates the responses of Elasticsearch 8 for an index, which pages the documents only in a point in time.
+type pointInTimeStandIn struct {
+	index string
+	count int
+
+	mu       sync.Mutex
+	pits     int // The number of the points in time opened
+	searches int // The number of the searches in the points in time
+}
+
+func (s *pointInTimeStandIn) write(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *pointInTimeStandIn) writeError(w http.ResponseWriter, status int, reason string) {
+	s.write(w, status, map[string]interface{}{"error": map[string]interface{}{"type": "illegal_argument_exception", "reason": reason}, "status": status})
+}
+
+func (s *pointInTimeStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var body struct {
+		From *int `json:"from"`
+		Size int  `json:"size"`
+		Pit  struct {
+			ID string `json:"id"`
+		} `json:"pit"`
+		Sort        json.RawMessage `json:"sort"`
+		SearchAfter []float64       `json:"search_after"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch {
+	case r.URL.Path == "/":
+		s.write(w, http.StatusOK, map[string]interface{}{"version": map[string]interface{}{"number": "8.11.0", "build_flavor": "default"}})
+	case r.URL.Path == "/"+s.index+"/_pit":
+		s.pits++
+		s.write(w, http.StatusOK, map[string]interface{}{"id": fmt.Sprintf("pit-%d", s.pits)})
+	case r.URL.Path == "/_search":
+		if body.Pit.ID != fmt.Sprintf("pit-%d", s.pits) || !strings.Contains(string(body.Sort), `"_shard_doc"`) {
+			s.writeError(w, http.StatusBadRequest, "search without the point in time or _shard_doc")
+			return
+		}
+		from := 0
+		if body.From != nil {
+			from = *body.From
+		}
+		if body.SearchAfter != nil {
+			from = int(body.SearchAfter[0]) + 1
+		} else if from+body.Size > maxResultWindow {
+			s.writeError(w, http.StatusBadRequest, "Result window is too large")
+			return
+		}
+		s.searches++
+		hits := []interface{}{}
+		for i := from; i < s.count && i < from+body.Size; i++ {
+			hits = append(hits, map[string]interface{}{"_index": s.index, "_id": fmt.Sprintf("%05d", i), "_source": map[string]interface{}{"n": i}, "sort": []interface{}{i}})
+		}
+		s.write(w, http.StatusOK, map[string]interface{}{"pit_id": body.Pit.ID, "hits": map[string]interface{}{"total": s.count, "hits": hits}})
+	default:
+		s.writeError(w, http.StatusBadRequest, "no handler for "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func TestHasShardDocSort(t *testing.T) {
+	for _, c := range []struct {
+		version  ClusterVersion
+		expected bool
+	}{
+		{ClusterVersion{Number: "7.10.2"}, false},
+		{ClusterVersion{Number: "7.12.0"}, true},
+		{ClusterVersion{Number: "8.11.0"}, true},
+		{ClusterVersion{Number: "2.11.0", Distribution: openSearchDistribution}, false},
+	} {
+		if c.version.HasShardDocSort() != c.expected {
+			t.Errorf("version=%+v, expected=%v", c.version, c.expected)
+		}
+	}
+}
+
+func TestPointInTimeBackend(t *testing.T) {
+	standIn := &pointInTimeStandIn{index: "idx", count: maxResultWindow + 35}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	backend, err := NewBackend(ClientConfig{URLs: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each page costs one search, since the cursors of the pages beyond the result window are kept by the cache
+	cache := &ElasticsearchCache{db: backend, pageSize: 10, updateInterval: time.Minute}
+	for _, c := range []struct {
+		page     int
+		searches int
+	}{
+		{0, 1},
+		{1, 1},
+		{maxResultWindow/10 + 1, 3}, // The cursors at the end of the window and of the page before are walked to
+		{maxResultWindow/10 + 2, 1},
+		{maxResultWindow/10 + 3, 1},
+		{maxResultWindow/10 + 5, 1}, // Beyond the last document, which is found by walking from the last cursor
+	} {
+		searches := standIn.searches
+		docs, err := cache.EnsureDocuments("idx", typelessDocType, c.page)
+		if err != nil {
+			t.Fatalf("page=%v, err=%v", c.page, err)
+		}
+		var expected []string
+		for i := c.page * 10; i < c.page*10+10 && i < standIn.count; i++ {
+			expected = append(expected, fmt.Sprintf("%05d", i))
+		}
+		var ids []string
+		for id := range docs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("unexpected documents in the page: page=%v, expected=%v, actual=%v", c.page, expected, ids)
+		}
+		if standIn.searches-searches != c.searches {
+			t.Errorf("unexpected number of searches: page=%v, expected=%v, actual=%v", c.page, c.searches, standIn.searches-searches)
+		}
+	}
+	if standIn.pits != 1 {
+		t.Errorf("the pages are not in the same point in time: pits=%v", standIn.pits)
+	}
+}
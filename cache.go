@@ -1,78 +1,618 @@
 package main
 
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ElasticsearchCache is safe for the concurrent use by the FUSE server.
+// The maps are guarded by the mutex, and the same queries in flight are sent to Elasticsearch only once.
+// The cached values are never modified in place, so the callers can read them without the lock.
 type ElasticsearchCache struct {
-	db         *ElasticsearchClient
-	pageSize   int
+	db             Backend
+	pageSize       int
+	updateInterval time.Duration
+
+	mu           sync.Mutex
+	flights      flightGroup
+	generation   uint64
+	indexNames   indexNamesEntry
+	docTypes     map[string]docTypesEntry
+	indexStats   map[string]indexStatsEntry
+	indexMeta    map[string]indexMetaEntry
+	docTotals    map[string]map[string]docTotalEntry
+	docs         map[string]map[string]map[int]docsEntry
+	searchDocs   map[string]map[string]map[int]docsEntry
+	searchTotals map[string]map[string]docTotalEntry
+	cursors      map[string]map[string]cursorsEntry
+}
+
+// Each entry keeps the time when it is fetched from Elasticsearch to expire after the update interval.
+type indexNamesEntry struct {
 	indexNames []string
-	docTypes   map[string][]string
-	docTotals  map[string]map[string]int64
-	docs       map[string]map[string]map[int]map[string][]byte
+	updatedAt  time.Time
+}
+
+type docTypesEntry struct {
+	docTypes  []string
+	updatedAt time.Time
+}
+
+type indexStatsEntry struct {
+	stats     IndexStats
+	updatedAt time.Time
+}
+
+type indexMetaEntry struct {
+	metadata  IndexMetadata
+	updatedAt time.Time
+}
+
+type docTotalEntry struct {
+	total     int64
+	updatedAt time.Time
+}
+
+type docsEntry struct {
+	docs      map[string]Document
+	updatedAt time.Time
+}
+
+// The cursors map the offsets of the documents to the sort values of the previous documents to search after them.
+// If the backend pages in a point in time, the cursors are valid only in the point in time.
+type cursorsEntry struct {
+	cursors   map[int][]interface{}
+	pit       *pointInTime
+	updatedAt time.Time
+}
+
+// pointInTime is the point in time to page the documents in, whose ID may be renewed by the searches in it.
+// The ID is guarded by the mutex of the cache.
+type pointInTime struct {
+	id string
 }
 
-func NewElasticsearchCache(urls string, pageSize int) (*ElasticsearchCache, error) {
-	db, err := NewElasticsearchClient(DeserializeDRLs(urls))
+// The time to keep the point in time alive after the cursors expire, so that the pages in flight can still use it
+const pointInTimeKeepAliveMargin = time.Minute
+
+func NewElasticsearchCache(clientConfig ClientConfig, pageSize int, updateInterval time.Duration) (*ElasticsearchCache, error) {
+	db, err := NewBackend(clientConfig)
 	if err != nil {
 		return nil, err
 	}
 	var c ElasticsearchCache
 	c.db = db
 	c.pageSize = pageSize
+	c.updateInterval = updateInterval
 	return &c, nil
 }
 
+// isFresh reports whether the entry fetched at the time can be served without querying Elasticsearch again.
+func (c *ElasticsearchCache) isFresh(updatedAt time.Time) bool {
+	return !updatedAt.IsZero() && time.Since(updatedAt) < c.updateInterval
+}
+
+// fetch queries Elasticsearch once for the concurrent callers with the same key, and stores the result by the store function.
+// The result is not stored if the cache is invalidated while the query is in flight, because it may be already stale.
+func (c *ElasticsearchCache) fetch(key string, query func() (interface{}, error), store func(value interface{})) (interface{}, error) {
+	return c.flights.Do(key, func() (interface{}, error) {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		value, err := query()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		if c.generation == generation {
+			store(value)
+		}
+		c.mu.Unlock()
+		return value, nil
+	})
+}
+
+// invalidate drops the entries by the function under the lock, and makes the queries in flight not to be stored.
+func (c *ElasticsearchCache) invalidate(drop func()) {
+	c.mu.Lock()
+	c.generation++
+	drop()
+	c.mu.Unlock()
+}
+
 func (c *ElasticsearchCache) EnsureIndexNames() ([]string, error) {
-	indexNames, err := c.db.GetIndexNames()
+	c.mu.Lock()
+	entry := c.indexNames
+	c.mu.Unlock()
+	if c.isFresh(entry.updatedAt) {
+		return entry.indexNames, nil
+	}
+	value, err := c.fetch("indexNames", func() (interface{}, error) {
+		return c.db.GetIndexNames()
+	}, func(value interface{}) {
+		c.indexNames = indexNamesEntry{indexNames: value.([]string), updatedAt: time.Now()}
+	})
 	if err != nil {
 		return nil, err
 	}
-	c.indexNames = indexNames
-	return indexNames, nil
+	return value.([]string), nil
 }
 
 func (c *ElasticsearchCache) EnsureDocumentTypes(index string) ([]string, error) {
-	docTypes, err := c.db.GetDocumentTypes(index)
+	c.mu.Lock()
+	entry, ok := c.docTypes[index]
+	c.mu.Unlock()
+	if ok && c.isFresh(entry.updatedAt) {
+		return entry.docTypes, nil
+	}
+	key := fmt.Sprintf("docTypes/%v", index)
+	value, err := c.fetch(key, func() (interface{}, error) {
+		return c.db.GetDocumentTypes(index)
+	}, func(value interface{}) {
+		if c.docTypes == nil {
+			c.docTypes = make(map[string]docTypesEntry)
+		}
+		c.docTypes[index] = docTypesEntry{docTypes: value.([]string), updatedAt: time.Now()}
+	})
 	if err != nil {
 		return nil, err
 	}
-	if c.docTypes == nil {
-		c.docTypes = make(map[string][]string)
+	return value.([]string), nil
+}
+
+func (c *ElasticsearchCache) EnsureIndexStats(index string) (IndexStats, error) {
+	c.mu.Lock()
+	entry, ok := c.indexStats[index]
+	c.mu.Unlock()
+	if ok && c.isFresh(entry.updatedAt) {
+		return entry.stats, nil
+	}
+	key := fmt.Sprintf("indexStats/%v", index)
+	value, err := c.fetch(key, func() (interface{}, error) {
+		return c.db.GetIndexStats(index)
+	}, func(value interface{}) {
+		if c.indexStats == nil {
+			c.indexStats = make(map[string]indexStatsEntry)
+		}
+		c.indexStats[index] = indexStatsEntry{stats: value.(IndexStats), updatedAt: time.Now()}
+	})
+	if err != nil {
+		return IndexStats{}, err
+	}
+	return value.(IndexStats), nil
+}
+
+func (c *ElasticsearchCache) EnsureIndexMetadata(index string) (IndexMetadata, error) {
+	c.mu.Lock()
+	entry, ok := c.indexMeta[index]
+	c.mu.Unlock()
+	if ok && c.isFresh(entry.updatedAt) {
+		return entry.metadata, nil
+	}
+	key := fmt.Sprintf("indexMeta/%v", index)
+	value, err := c.fetch(key, func() (interface{}, error) {
+		return c.db.GetIndexMetadata(index)
+	}, func(value interface{}) {
+		if c.indexMeta == nil {
+			c.indexMeta = make(map[string]indexMetaEntry)
+		}
+		c.indexMeta[index] = indexMetaEntry{metadata: value.(IndexMetadata), updatedAt: time.Now()}
+	})
+	if err != nil {
+		return IndexMetadata{}, err
 	}
-	c.docTypes[index] = docTypes
-	return docTypes, nil
+	return value.(IndexMetadata), nil
 }
 
 func (c *ElasticsearchCache) EnsureDocumentTotal(index string, docType string) (int64, error) {
-	total, err := c.db.CountDocuments(index, docType)
+	c.mu.Lock()
+	entry, ok := c.docTotals[index][docType]
+	c.mu.Unlock()
+	if ok && c.isFresh(entry.updatedAt) {
+		return entry.total, nil
+	}
+	key := fmt.Sprintf("docTotal/%v/%v", index, docType)
+	value, err := c.fetch(key, func() (interface{}, error) {
+		return c.db.CountDocuments(index, docType)
+	}, func(value interface{}) {
+		if c.docTotals == nil {
+			c.docTotals = make(map[string]map[string]docTotalEntry)
+		}
+		_, ok := c.docTotals[index]
+		if !ok {
+			c.docTotals[index] = make(map[string]docTotalEntry)
+		}
+		c.docTotals[index][docType] = docTotalEntry{total: value.(int64), updatedAt: time.Now()}
+	})
 	if err != nil {
 		return 0, err
 	}
-	if c.docTotals == nil {
-		c.docTotals = make(map[string]map[string]int64)
+	return value.(int64), nil
+}
+
+func (c *ElasticsearchCache) EnsureDocuments(index string, docType string, page int) (map[string]Document, error) {
+	c.mu.Lock()
+	entry, ok := c.docs[index][docType][page]
+	c.mu.Unlock()
+	if ok && c.isFresh(entry.updatedAt) {
+		return entry.docs, nil
 	}
-	_, ok := c.docTotals[index]
-	if !ok {
-		c.docTotals[index] = make(map[string]int64)
+	key := fmt.Sprintf("docs/%v/%v/%v", index, docType, page)
+	value, err := c.fetch(key, func() (interface{}, error) {
+		return c.getPage(index, docType, page)
+	}, func(value interface{}) {
+		if c.docs == nil {
+			c.docs = make(map[string]map[string]map[int]docsEntry)
+		}
+		_, ok := c.docs[index]
+		if !ok {
+			c.docs[index] = make(map[string]map[int]docsEntry)
+		}
+		_, ok = c.docs[index][docType]
+		if !ok {
+			c.docs[index][docType] = make(map[int]docsEntry)
+		}
+		c.docs[index][docType][page] = docsEntry{docs: value.(map[string]Document), updatedAt: time.Now()}
+	})
+	if err != nil {
+		return nil, err
 	}
-	c.docTotals[index][docType] = total
-	return total, nil
+	return value.(map[string]Document), nil
 }
 
-func (c *ElasticsearchCache) EnsureDocuments(index string, docType string, page int) (map[string][]byte, error) {
-	docs, err := c.db.GetDocuments(index, docType, c.pageSize*page, c.pageSize)
+// getPage gets the page of the documents from Elasticsearch.
+// The pages beyond maxResultWindow are searched after the cursors, which are walked through from the nearest known one,
+// unless the backend scrolls through the documents by itself without a point in time.
+func (c *ElasticsearchCache) getPage(index string, docType string, page int) (map[string]Document, error) {
+	entry, err := c.ensureCursors(index, docType)
 	if err != nil {
 		return nil, err
 	}
-	if c.docs == nil {
-		c.docs = make(map[string]map[string]map[int]map[string][]byte)
+	from := c.pageSize * page
+	if from+c.pageSize <= maxResultWindow {
+		docs, _, err := c.getPagedDocuments(index, docType, entry, from, nil, c.pageSize, true)
+		return docs, err
 	}
-	_, ok := c.docs[index]
-	if !ok {
-		c.docs[index] = make(map[string]map[int]map[string][]byte)
+	scroller, ok := c.db.(DocumentScroller)
+	if ok && entry.pit == nil {
+		return scroller.ScrollDocuments(index, docType, from, c.pageSize)
+	}
+	cursor, ok, err := c.ensureCursor(index, docType, entry, from)
+	if err != nil {
+		return nil, err
 	}
-	_, ok = c.docs[index][docType]
 	if !ok {
-		c.docs[index][docType] = make(map[int]map[string][]byte)
+		// The page is beyond the last document
+		return make(map[string]Document), nil
+	}
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+	docs, lastSort, err := c.getPagedDocuments(index, docType, entry, 0, cursor, c.pageSize, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The last document of the page is the cursor of the next page
+	c.mu.Lock()
+	if c.generation == generation && len(docs) == c.pageSize {
+		entry.cursors[from+c.pageSize] = lastSort
 	}
-	c.docs[index][docType][page] = docs
+	c.mu.Unlock()
 	return docs, nil
 }
+
+// ensureCursors returns the cursors of the document type, with a new point in time to page in if the backend pages in it.
+func (c *ElasticsearchCache) ensureCursors(index string, docType string) (cursorsEntry, error) {
+	c.mu.Lock()
+	entry, ok := c.cursors[index][docType]
+	c.mu.Unlock()
+	if ok && c.isFresh(entry.updatedAt) {
+		return entry, nil
+	}
+	key := fmt.Sprintf("cursors/%v/%v", index, docType)
+	value, err := c.fetch(key, func() (interface{}, error) {
+		entry := cursorsEntry{cursors: make(map[int][]interface{}), updatedAt: time.Now()}
+		pager, ok := c.db.(PointInTimePager)
+		if !ok {
+			return entry, nil
+		}
+		// The point in time outlives the cursors, and it expires by itself since the pages in flight may still use it
+		id, err := pager.OpenPointInTime(index, c.updateInterval+pointInTimeKeepAliveMargin)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" {
+			entry.pit = &pointInTime{id: id}
+		}
+		return entry, nil
+	}, func(value interface{}) {
+		if c.cursors == nil {
+			c.cursors = make(map[string]map[string]cursorsEntry)
+		}
+		_, ok := c.cursors[index]
+		if !ok {
+			c.cursors[index] = make(map[string]cursorsEntry)
+		}
+		c.cursors[index][docType] = value.(cursorsEntry)
+	})
+	if err != nil {
+		return cursorsEntry{}, err
+	}
+	return value.(cursorsEntry), nil
+}
+
+// getPagedDocuments gets a page of the documents, in the point in time of the cursors if they have one.
+// The cursors are dropped if the search in their point in time fails, since it may have expired.
+func (c *ElasticsearchCache) getPagedDocuments(index string, docType string, entry cursorsEntry, from int, searchAfter []interface{}, size int, fetchSource bool) (map[string]Document, []interface{}, error) {
+	if entry.pit == nil {
+		return c.db.GetPagedDocuments(index, docType, from, searchAfter, size, fetchSource)
+	}
+	c.mu.Lock()
+	id := entry.pit.id
+	c.mu.Unlock()
+	docs, lastSort, id, err := c.db.(PointInTimePager).GetPointInTimeDocuments(id, c.updateInterval+pointInTimeKeepAliveMargin, from, searchAfter, size, fetchSource)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		current, ok := c.cursors[index][docType]
+		if ok && current.pit == entry.pit {
+			delete(c.cursors[index], docType)
+		}
+		return nil, nil, err
+	}
+	if id != "" {
+		entry.pit.id = id
+	}
+	return docs, lastSort, nil
+}
+
+// ensureCursor returns the sort values to search after for the documents at the offset.
+// It returns false if the offset is beyond the last document.
+func (c *ElasticsearchCache) ensureCursor(index string, docType string, entry cursorsEntry, offset int) ([]interface{}, bool, error) {
+	c.mu.Lock()
+	generation := c.generation
+	start := 0
+	var cursor []interface{}
+	for knownOffset, values := range entry.cursors {
+		if start < knownOffset && knownOffset <= offset {
+			start = knownOffset
+			cursor = values
+		}
+	}
+	c.mu.Unlock()
+
+	for start < offset {
+		size := offset - start
+		if size > maxResultWindow {
+			size = maxResultWindow
+		}
+		docs, lastSort, err := c.getPagedDocuments(index, docType, entry, start, cursor, size, false)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(docs) < size {
+			return nil, false, nil
+		}
+		start += size
+		cursor = lastSort
+
+		c.mu.Lock()
+		if c.generation == generation {
+			entry.cursors[start] = cursor
+		}
+		c.mu.Unlock()
+	}
+	return cursor, true, nil
+}
+
+// EnsureSearchTotal returns the number of the documents matching the query DSL in the index.
+func (c *ElasticsearchCache) EnsureSearchTotal(index string, query []byte) (int64, error) {
+	search := string(query)
+	c.mu.Lock()
+	entry, ok := c.searchTotals[index][search]
+	c.mu.Unlock()
+	if ok && c.isFresh(entry.updatedAt) {
+		return entry.total, nil
+	}
+	key := fmt.Sprintf("searchTotal/%v/%v", index, search)
+	value, err := c.fetch(key, func() (interface{}, error) {
+		return c.db.CountMatchingDocuments(index, query)
+	}, func(value interface{}) {
+		if c.searchTotals == nil {
+			c.searchTotals = make(map[string]map[string]docTotalEntry)
+		}
+		_, ok := c.searchTotals[index]
+		if !ok {
+			c.searchTotals[index] = make(map[string]docTotalEntry)
+		}
+		c.searchTotals[index][search] = docTotalEntry{total: value.(int64), updatedAt: time.Now()}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value.(int64), nil
+}
+
+// EnsureSearchDocuments returns the page of the documents matching the query DSL in the index, ordered by the sort DSL.
+// The pages are searched by from and size, so the ones beyond maxResultWindow are empty.
+func (c *ElasticsearchCache) EnsureSearchDocuments(index string, query []byte, sort []byte, page int) (map[string]Document, error) {
+	search := fmt.Sprintf("%s/%s", query, sort)
+	c.mu.Lock()
+	entry, ok := c.searchDocs[index][search][page]
+	c.mu.Unlock()
+	if ok && c.isFresh(entry.updatedAt) {
+		return entry.docs, nil
+	}
+	key := fmt.Sprintf("searchDocs/%v/%v/%v", index, search, page)
+	value, err := c.fetch(key, func() (interface{}, error) {
+		from := c.pageSize * page
+		size := c.pageSize
+		if from+size > maxResultWindow {
+			size = maxResultWindow - from
+		}
+		if size <= 0 {
+			return make(map[string]Document), nil
+		}
+		return c.db.GetDocuments(index, "", query, sort, from, size)
+	}, func(value interface{}) {
+		if c.searchDocs == nil {
+			c.searchDocs = make(map[string]map[string]map[int]docsEntry)
+		}
+		_, ok := c.searchDocs[index]
+		if !ok {
+			c.searchDocs[index] = make(map[string]map[int]docsEntry)
+		}
+		_, ok = c.searchDocs[index][search]
+		if !ok {
+			c.searchDocs[index][search] = make(map[int]docsEntry)
+		}
+		c.searchDocs[index][search][page] = docsEntry{docs: value.(map[string]Document), updatedAt: time.Now()}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]Document), nil
+}
+
+func (c *ElasticsearchCache) SaveDocument(index string, docType string, id string, routing string, source []byte) (string, error) {
+	meta, err := c.db.IndexDocument(index, docType, id, routing, source)
+	if err != nil {
+		return "", err
+	}
+	id = meta.ID
+	c.invalidate(func() {
+		delete(c.searchDocs, index)
+		delete(c.searchTotals, index)
+		delete(c.indexStats, index)
+		if c.replaceDocument(index, docType, Document{Source: source, Meta: meta}) {
+			return
+		}
+		// A new document may shift the others across the pages
+		delete(c.docs[index], docType)
+		delete(c.docTotals[index], docType)
+		delete(c.cursors[index], docType)
+	})
+	return id, nil
+}
+
+// UpdateDocument merges the partial document into the document, and replaces the cached one with the updated one.
+func (c *ElasticsearchCache) UpdateDocument(index string, docType string, id string, routing string, partial []byte) error {
+	doc, err := c.db.UpdateDocument(index, docType, id, routing, partial)
+	if err != nil {
+		return err
+	}
+	c.invalidate(func() {
+		delete(c.searchDocs, index)
+		delete(c.searchTotals, index)
+		delete(c.indexStats, index)
+		c.replaceDocument(index, docType, doc)
+	})
+	return nil
+}
+
+// replaceDocument replaces the document in the cached page which has it, and reports whether it is found.
+// It must be called while the cache is locked.
+func (c *ElasticsearchCache) replaceDocument(index string, docType string, doc Document) bool {
+	for page, entry := range c.docs[index][docType] {
+		_, ok := entry.docs[doc.Meta.ID]
+		if ok {
+			// Replace the page instead of updating it, because the callers may be reading it
+			docs := make(map[string]Document, len(entry.docs))
+			for docID, cached := range entry.docs {
+				docs[docID] = cached
+			}
+			docs[doc.Meta.ID] = doc
+			c.docs[index][docType][page] = docsEntry{docs: docs, updatedAt: entry.updatedAt}
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ElasticsearchCache) DeleteDocument(index string, docType string, id string, routing string) error {
+	err := c.db.DeleteDocument(index, docType, id, routing)
+	if err != nil {
+		return err
+	}
+	// The deletion shifts the following documents across the pages
+	c.invalidate(func() {
+		delete(c.docs[index], docType)
+		delete(c.docTotals[index], docType)
+		delete(c.cursors[index], docType)
+		delete(c.searchDocs, index)
+		delete(c.searchTotals, index)
+		delete(c.indexStats, index)
+	})
+	return nil
+}
+
+func (c *ElasticsearchCache) DeleteIndex(index string) error {
+	err := c.db.DeleteIndex(index)
+	if err != nil {
+		return err
+	}
+	c.invalidate(func() {
+		c.indexNames = indexNamesEntry{}
+		delete(c.docTypes, index)
+		delete(c.indexStats, index)
+		delete(c.indexMeta, index)
+		delete(c.docTotals, index)
+		delete(c.docs, index)
+		delete(c.searchDocs, index)
+		delete(c.searchTotals, index)
+		delete(c.cursors, index)
+	})
+	return nil
+}
+
+func (c *ElasticsearchCache) CreateIndex(index string, body []byte) error {
+	err := c.db.CreateIndex(index, body)
+	if err != nil {
+		return err
+	}
+	c.invalidate(func() {
+		c.indexNames = indexNamesEntry{}
+	})
+	return nil
+}
+
+func (c *ElasticsearchCache) PutMapping(index string, docType string, mapping []byte) error {
+	err := c.db.PutMapping(index, docType, mapping)
+	if err != nil {
+		return err
+	}
+	c.invalidate(func() {
+		delete(c.docTypes, index)
+		delete(c.indexMeta, index)
+	})
+	return nil
+}
+
+func (c *ElasticsearchCache) PutSettings(index string, settings []byte) error {
+	err := c.db.PutSettings(index, settings)
+	if err != nil {
+		return err
+	}
+	c.invalidate(func() {
+		delete(c.indexStats, index)
+		delete(c.indexMeta, index)
+	})
+	return nil
+}
+
+// UpdateAliases applies the actions on the aliases of the index.
+func (c *ElasticsearchCache) UpdateAliases(index string, actions []byte) error {
+	err := c.db.UpdateAliases(actions)
+	if err != nil {
+		return err
+	}
+	c.invalidate(func() {
+		delete(c.indexMeta, index)
+	})
+	return nil
+}
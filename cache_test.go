@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// newSearchCountingStandIn returns the stand-in of Elasticsearch 5 with a document in idx/t,
+// which counts the searches and holds them until the release channel is closed.
+func newSearchCountingStandIn(searches *int32, release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		switch r.URL.Path {
+		case "/":
+			body = map[string]interface{}{"version": map[string]interface{}{"number": "5.6.16"}}
+		case "/idx/t/_search":
+			atomic.AddInt32(searches, 1)
+			<-release
+			hit := map[string]interface{}{"_index": "idx", "_type": "t", "_id": "doc", "_version": 1, "_source": map[string]interface{}{"n": 1}, "sort": []interface{}{"t#doc"}}
+			body = map[string]interface{}{"hits": map[string]interface{}{"total": 1, "hits": []interface{}{hit}}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			body = map[string]interface{}{"error": map[string]interface{}{"type": "resource_not_found_exception", "reason": r.URL.Path}, "status": http.StatusNotFound}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}))
+}
+
+func TestConcurrentGetAttr(t *testing.T) {
+	var searches int32
+	release := make(chan struct{})
+	server := newSearchCountingStandIn(&searches, release)
+	defer server.Close()
+	fs, err := NewElasticsearchFS(ClientConfig{URLs: []string{server.URL}}, FSOptions{PageSize: 10, UpdateInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]fuse.Status, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, codes[i] = fs.GetAttr("idx/t/0/doc", nil)
+		}(i)
+	}
+	// The calls wait for the search in flight until it is answered
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, code := range codes {
+		if !code.Ok() {
+			t.Errorf("GetAttr failed: call=%v, code=%v", i, code)
+		}
+	}
+	if atomic.LoadInt32(&searches) != 1 {
+		t.Errorf("the concurrent calls are not deduplicated: searches=%v", atomic.LoadInt32(&searches))
+	}
+
+	// The page is served from the cache within the update interval
+	_, code := fs.GetAttr("idx/t/0/doc", nil)
+	if !code.Ok() || atomic.LoadInt32(&searches) != 1 {
+		t.Errorf("the page is not cached: code=%v, searches=%v", code, atomic.LoadInt32(&searches))
+	}
+}
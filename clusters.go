@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+// ClustersFS mounts the filesystems of the clusters as the directories by their names.
+type ClustersFS struct {
+	pathfs.FileSystem
+
+	clusters map[string]pathfs.FileSystem
+}
+
+func NewClustersFS(clusters map[string]pathfs.FileSystem) *ClustersFS {
+	var fs ClustersFS
+	fs.FileSystem = pathfs.NewDefaultFileSystem()
+	fs.clusters = clusters
+	return &fs
+}
+
+// cluster returns the filesystem of the cluster which has the path, and the path in it.
+func (fs *ClustersFS) cluster(name string) (pathfs.FileSystem, string, bool) {
+	nameElems := strings.SplitN(name, "/", 2)
+	cluster, ok := fs.clusters[nameElems[0]]
+	if !ok {
+		return nil, "", false
+	}
+	if len(nameElems) == 1 {
+		return cluster, "", true
+	}
+	return cluster, nameElems[1], true
+}
+
+func (fs *ClustersFS) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	if name == "" {
+		return &fuse.Attr{Mode: fuse.S_IFDIR | 0555}, fuse.OK
+	}
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return cluster.GetAttr(clusterName, context)
+}
+
+func (fs *ClustersFS) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	if name == "" {
+		var entries []fuse.DirEntry
+		for clusterName := range fs.clusters {
+			entries = append(entries, fuse.DirEntry{Name: clusterName, Mode: fuse.S_IFDIR})
+		}
+		return entries, fuse.OK
+	}
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return cluster.OpenDir(clusterName, context)
+}
+
+func (fs *ClustersFS) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return cluster.Open(clusterName, flags, context)
+}
+
+func (fs *ClustersFS) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok {
+		return fuse.ENOENT
+	}
+	return cluster.Truncate(clusterName, size, context)
+}
+
+func (fs *ClustersFS) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok || clusterName == "" {
+		return nil, fuse.EPERM
+	}
+	return cluster.Create(clusterName, flags, mode, context)
+}
+
+func (fs *ClustersFS) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok || clusterName == "" {
+		return fuse.EPERM
+	}
+	return cluster.Mkdir(clusterName, mode, context)
+}
+
+func (fs *ClustersFS) Unlink(name string, context *fuse.Context) fuse.Status {
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok || clusterName == "" {
+		return fuse.EPERM
+	}
+	return cluster.Unlink(clusterName, context)
+}
+
+func (fs *ClustersFS) Rmdir(name string, context *fuse.Context) fuse.Status {
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok || clusterName == "" {
+		return fuse.EPERM
+	}
+	return cluster.Rmdir(clusterName, context)
+}
+
+func (fs *ClustersFS) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok {
+		return nil, fuse.ENODATA
+	}
+	return cluster.GetXAttr(clusterName, attribute, context)
+}
+
+func (fs *ClustersFS) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	cluster, clusterName, ok := fs.cluster(name)
+	if !ok {
+		return nil, fuse.OK
+	}
+	return cluster.ListXAttr(clusterName, context)
+}
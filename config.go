@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the configuration to mount the filesystem, which is built from the flags and the mount options.
+type Config struct {
+	Client           ClientConfig
+	Clusters         map[string]ClientConfig // The named clusters to mount instead of the client, if any
+	MountPath        string
+	PageSize         int
+	Format           string // The format to show the documents in
+	Flatten          bool   // Whether to show the documents as the directories of their fields
+	UpdateInterval   time.Duration
+	QueriesPath      string
+	AllowDestructive bool
+	Debug            bool
+
+	// The options of the FUSE mount
+	ReadOnly           bool
+	AllowOther         bool
+	DefaultPermissions bool
+	UID                int // The owner of the files, or -1 for the user who mounts
+	GID                int // The group of the files, or -1 for the group of the user who mounts
+
+	// The options of the process
+	Foreground bool
+	PidPath    string
+}
+
+// The flags of the client settings, which can be given to each cluster in the configuration file
+var clientFlags = map[string]bool{
+	"urls":                 true,
+	"username":             true,
+	"password-file":        true,
+	"api-key-file":         true,
+	"bearer-token-file":    true,
+	"ca-cert":              true,
+	"client-cert":          true,
+	"client-key":           true,
+	"insecure-skip-verify": true,
+	"sniff":                true,
+	"healthcheck":          true,
+	"timeout":              true,
+	"retries":              true,
+}
+
+// The prefix of the environment variables to give the flags, like ELASTICSEARCH_FUSE_PAGE for --page
+const envPrefix = "ELASTICSEARCH_FUSE_"
+
+// NewConfig builds the configuration from the flags, the mount options of -o and the positional arguments.
+// The flags which are not given are taken from the environment variables, and then from the configuration file.
+func NewConfig(c *cli.Context, args cli.Args) (*Config, error) {
+	// Take the options of -o as the flags which are not given explicitly
+	err := ApplyMountOptions(c, ParseMountOptions(c.StringSlice("o")))
+	if err != nil {
+		return nil, err
+	}
+	err = applyEnv(c)
+	if err != nil {
+		return nil, err
+	}
+	var clusters map[string]map[string]string
+	if c.String("config") != "" {
+		var values map[string]string
+		values, clusters, err = LoadConfigFile(c.String("config"))
+		if err != nil {
+			return nil, err
+		}
+		err = applyConfigFile(c, values)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var config Config
+	urls := c.String("urls")
+	config.MountPath = c.String("mount-path")
+	config.PageSize = c.Int("page")
+	config.Format = c.String("format")
+	config.Flatten = c.Bool("flatten")
+	if !IsValidFormat(config.Format) {
+		return nil, fmt.Errorf("unknown format: format=%v", config.Format)
+	}
+	config.UpdateInterval = time.Duration(c.Int("update-interval")) * time.Second
+	config.QueriesPath = c.String("queries")
+	config.AllowDestructive = c.Bool("allow-destructive")
+	config.Debug = c.Bool("debug")
+	config.ReadOnly = c.Bool("read-only")
+	config.AllowOther = c.Bool("allow-other")
+	config.DefaultPermissions = c.Bool("default-permissions")
+	config.UID = c.Int("uid")
+	config.GID = c.Int("gid")
+	config.Foreground = c.Bool("foreground")
+	config.PidPath = c.String("pidfile")
+
+	// The positional arguments are preferred to the flags, as mount(8) gives them
+	if len(args) > 2 {
+		return nil, fmt.Errorf("too many arguments: %v", strings.Join(args, " "))
+	}
+	if len(args) >= 1 {
+		urls = args.Get(0)
+	}
+	if len(args) >= 2 {
+		config.MountPath = args.Get(1)
+	}
+	config.Client, err = newClientConfig(urls, c.String)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each of the clusters in the configuration file inherits the settings of the client which it does not have
+	for name, settings := range clusters {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid cluster name: name=%v", name)
+		}
+		if settings["urls"] == "" {
+			return nil, fmt.Errorf("the cluster has no URLs: name=%v", name)
+		}
+		for key := range settings {
+			if !clientFlags[key] {
+				return nil, fmt.Errorf("unknown setting of the cluster: name=%v, setting=%v", name, key)
+			}
+		}
+		client, err := newClientConfig(settings["urls"], func(key string) string {
+			value, ok := settings[key]
+			if ok {
+				return value
+			}
+			return c.String(key)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid settings of the cluster: name=%v, err=%v", name, err)
+		}
+		if config.Clusters == nil {
+			config.Clusters = make(map[string]ClientConfig)
+		}
+		config.Clusters[name] = client
+	}
+	return &config, nil
+}
+
+// newClientConfig builds the configuration of the client from the settings by the names of the flags.
+func newClientConfig(urls string, setting func(name string) string) (ClientConfig, error) {
+	var config ClientConfig
+	var err error
+	config.URLs = DeserializeDRLs(urls)
+
+	// Read the credentials from the files or the environment variables, so that they do not appear in the process list
+	config.Username = setting("username")
+	config.Password, err = ReadSecret(setting("password-file"), passwordEnv)
+	if err != nil {
+		return config, err
+	}
+	config.APIKey, err = ReadSecret(setting("api-key-file"), apiKeyEnv)
+	if err != nil {
+		return config, err
+	}
+	config.BearerToken, err = ReadSecret(setting("bearer-token-file"), bearerTokenEnv)
+	if err != nil {
+		return config, err
+	}
+	credentials := 0
+	for _, credential := range []string{config.Username, config.APIKey, config.BearerToken} {
+		if credential != "" {
+			credentials++
+		}
+	}
+	if credentials > 1 {
+		return config, errors.New("only one of the username, the API key and the bearer token can be given")
+	}
+
+	config.CACert = setting("ca-cert")
+	config.ClientCert = setting("client-cert")
+	config.ClientKey = setting("client-key")
+	config.InsecureSkipVerify, err = strconv.ParseBool(setting("insecure-skip-verify"))
+	if err != nil {
+		return config, err
+	}
+	config.Sniff, err = strconv.ParseBool(setting("sniff"))
+	if err != nil {
+		return config, err
+	}
+	config.Healthcheck, err = strconv.ParseBool(setting("healthcheck"))
+	if err != nil {
+		return config, err
+	}
+	timeout, err := strconv.Atoi(setting("timeout"))
+	if err != nil {
+		return config, err
+	}
+	config.Timeout = time.Duration(timeout) * time.Second
+	config.MaxRetries, err = strconv.Atoi(setting("retries"))
+	if err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// LoadConfigFile reads the YAML file which maps the names of the flags to their values,
+// and returns them with the settings of the clusters, like:
+//
+//	page: 20
+//	allow-destructive: true
+//	clusters:
+//	  prod:
+//	    urls: [http://es1:9200, http://es2:9200]
+//	    api-key-file: /etc/elasticsearch-fuse/prod.key
+//	  local:
+//	    urls: http://localhost:9200
+func LoadConfigFile(path string) (map[string]string, map[string]map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var file map[string]interface{}
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var clusters map[string]map[string]string
+	if value, ok := file["clusters"]; ok {
+		delete(file, "clusters")
+		clusterFiles, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil, nil, errors.New("the clusters must be a mapping of the names to the settings")
+		}
+		clusters = make(map[string]map[string]string, len(clusterFiles))
+		for name, clusterFile := range clusterFiles {
+			settings, ok := clusterFile.(map[interface{}]interface{})
+			if !ok {
+				return nil, nil, fmt.Errorf("the settings of the cluster must be a mapping: name=%v", name)
+			}
+			clusterSettings := make(map[string]interface{}, len(settings))
+			for key, value := range settings {
+				clusterSettings[fmt.Sprint(key)] = value
+			}
+			clusters[fmt.Sprint(name)], err = configValues(clusterSettings)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	values, err := configValues(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return values, clusters, nil
+}
+
+// configValues converts the values in the configuration file into the ones of the flags.
+func configValues(file map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(file))
+	for name, value := range file {
+		switch v := value.(type) {
+		case []interface{}:
+			// The lists such as the URLs are given as the comma-separated values
+			elems := make([]string, len(v))
+			for i, elem := range v {
+				elems[i] = fmt.Sprint(elem)
+			}
+			values[name] = strings.Join(elems, ",")
+		case map[interface{}]interface{}:
+			return nil, fmt.Errorf("the value must not be a mapping: name=%v", name)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// applyEnv sets the environment variables to the flags which are not given explicitly.
+func applyEnv(c *cli.Context) error {
+	for name := range flagNames(c.App.Flags) {
+		env := envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+		value, ok := os.LookupEnv(env)
+		if !ok || c.IsSet(name) {
+			continue
+		}
+		err := c.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("invalid environment variable: name=%v, err=%v", env, err)
+		}
+	}
+	return nil
+}
+
+// applyConfigFile sets the values in the configuration file to the flags which are not given by the others.
+func applyConfigFile(c *cli.Context, values map[string]string) error {
+	names := flagNames(c.App.Flags)
+	for name, value := range values {
+		if !names[name] || name == "config" {
+			return fmt.Errorf("unknown setting in the configuration file: name=%v", name)
+		}
+		if c.IsSet(name) {
+			continue
+		}
+		err := c.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("invalid setting in the configuration file: name=%v, err=%v", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration as far as it can without mounting the filesystem.
+func (config *Config) Validate() error {
+	for _, client := range config.Clients() {
+		for _, rawURL := range client.URLs {
+			u, err := url.Parse(rawURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid URL: url=%v", RedactURL(rawURL))
+			}
+		}
+		if client.MaxRetries < 0 {
+			return fmt.Errorf("the retries must not be negative: retries=%v", client.MaxRetries)
+		}
+		_, err := NewTLSConfig(client)
+		if err != nil {
+			return err
+		}
+	}
+	if config.PageSize <= 0 {
+		return fmt.Errorf("the page size must be positive: page=%v", config.PageSize)
+	}
+	info, err := os.Stat(config.MountPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("the mount path is not a directory: path=%v", config.MountPath)
+	}
+	_, err = config.SavedQueries()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SavedQueries loads the saved queries of the --queries file, if any.
+// The queries must search the clusters which are mounted, so the cluster of a query must be one of the named clusters.
+func (config *Config) SavedQueries() (map[string]SavedQuery, error) {
+	if config.QueriesPath == "" {
+		return nil, nil
+	}
+	queries, err := LoadSavedQueries(config.QueriesPath)
+	if err != nil {
+		return nil, err
+	}
+	for name, query := range queries {
+		if query.Cluster == "" {
+			continue
+		}
+		if len(config.Clusters) == 0 {
+			return nil, fmt.Errorf("the saved query names a cluster, but no clusters are named: name=%v, cluster=%v", name, query.Cluster)
+		}
+		_, ok := config.Clusters[query.Cluster]
+		if !ok {
+			return nil, fmt.Errorf("the saved query names an unknown cluster: name=%v, cluster=%v", name, query.Cluster)
+		}
+	}
+	return queries, nil
+}
+
+// Clients returns the configurations of the clusters by their names.
+// If no cluster is named, the configuration of the only client is returned by the empty name.
+func (config *Config) Clients() map[string]ClientConfig {
+	if len(config.Clusters) > 0 {
+		return config.Clusters
+	}
+	return map[string]ClientConfig{"": config.Client}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// parseConfig runs the command with the arguments, and returns the configuration built from them.
+func parseConfig(args ...string) (*Config, error) {
+	app := cli.NewApp()
+	app.Flags = appFlags()
+	var config *Config
+	app.Action = func(c *cli.Context) error {
+		var err error
+		config, err = NewConfig(c, c.Args())
+		return err
+	}
+	err := app.Run(append([]string{"elasticsearch-fuse"}, args...))
+	return config, err
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elasticsearch-fuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(path, []byte("page: 40\nupdate-interval: 40\nformat: yaml\nretries: 4\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"PAGE": "30", "UPDATE_INTERVAL": "30", "FORMAT": "ndjson"} {
+		os.Setenv(envPrefix+name, value)
+		defer os.Unsetenv(envPrefix + name)
+	}
+
+	// The flags are preferred to -o, -o to the environment variables, and the environment variables to the configuration file
+	config, err := parseConfig("--config", path, "--page", "10", "-o", "page=20,ttl=20", "http://localhost:9200", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PageSize != 10 {
+		t.Errorf("the flag is not preferred: page=%v", config.PageSize)
+	}
+	if config.UpdateInterval != 20*time.Second {
+		t.Errorf("the mount option is not preferred: update-interval=%v", config.UpdateInterval)
+	}
+	if config.Format != ndjsonFormat {
+		t.Errorf("the environment variable is not preferred: format=%v", config.Format)
+	}
+	if config.Client.MaxRetries != 4 {
+		t.Errorf("the configuration file is not applied: retries=%v", config.Client.MaxRetries)
+	}
+	if config.MountPath != dir || len(config.Client.URLs) != 1 || config.Client.URLs[0] != "http://localhost:9200" {
+		t.Errorf("the positional arguments are not applied: urls=%v, mount-path=%v", config.Client.URLs, config.MountPath)
+	}
+}
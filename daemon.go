@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"log/syslog"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// The environment variable to tell the started process that it runs as the daemon
+const daemonEnv = "ELASTICSEARCH_FUSE_DAEMON"
+
+// The file descriptor of the pipe to report the result of the mount from the daemon to its parent
+const daemonReadyFd = 3
+
+var notifyOnce sync.Once
+
+// IsDaemon reports whether the process is started by Daemonize.
+func IsDaemon() bool {
+	return os.Getenv(daemonEnv) == "1"
+}
+
+// Daemonize starts the same command with the arguments detached from the terminal, and waits until it mounts the filesystem.
+// The arguments must be the ones converted from the mount helper, because the daemon is started by the path of the executable, not by the name of the helper.
+// The error of the daemon is returned, so that the command exits with failure as the mount(8) helpers do.
+func Daemonize(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	// The daemon writes nothing and closes the pipe on success, or writes the error message
+	message, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(message) > 0 {
+		cmd.Wait()
+		return errors.New(string(message))
+	}
+	return cmd.Process.Release()
+}
+
+// SetupDaemon redirects the logs of the daemon to syslog, because it has no terminal.
+func SetupDaemon(name string) {
+	writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, name)
+	if err != nil {
+		log.SetOutput(ioutil.Discard)
+		return
+	}
+	log.SetFlags(0)
+	log.SetOutput(writer)
+}
+
+// NotifyDaemonParent reports the result of the mount to the parent waiting in Daemonize.
+// It does nothing unless the process is the daemon, and only the first call takes effect.
+func NotifyDaemonParent(err error) {
+	if !IsDaemon() {
+		return
+	}
+	notifyOnce.Do(func() {
+		pipe := os.NewFile(daemonReadyFd, "daemon-ready")
+		if err != nil {
+			pipe.WriteString(err.Error())
+		}
+		pipe.Close()
+	})
+}
+
+// WritePidFile writes the process ID into the file, and returns the function to remove it.
+func WritePidFile(path string) (func(), error) {
+	err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		os.Remove(path)
+	}, nil
+}
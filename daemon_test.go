@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The environment variable to tell the daemon started by the tests where to record its arguments
+const testArgsEnv = "ELASTICSEARCH_FUSE_TEST_ARGS"
+
+func TestMain(m *testing.M) {
+	// The daemon started by Daemonize runs the test binary, which records its arguments instead of mounting
+	if IsDaemon() {
+		err := ioutil.WriteFile(os.Getenv(testArgsEnv), []byte(strings.Join(os.Args[1:], "\n")), 0644)
+		NotifyDaemonParent(err)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestDaemonizeMountHelper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elasticsearch-fuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "args")
+	os.Setenv(testArgsEnv, path)
+	defer os.Unsetenv(testArgsEnv)
+
+	// mount(8) runs the helper with the filesystem type, which is not a flag of the command
+	args, mount := MountHelperArgs([]string{"/sbin/mount.fuse.elasticsearch", "http://127.0.0.1:1", "/mnt/elasticsearch", "-o", "rw,page=5", "-t", "fuse.elasticsearch"})
+	if !mount {
+		t.Fatal("the filesystem is not mounted without -f")
+	}
+	err = Daemonize(args[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"http://127.0.0.1:1", "/mnt/elasticsearch", "-o", "rw,page=5"}
+	actual := strings.Split(string(data), "\n")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("the daemon is started with the unexpected arguments: expected=%v, actual=%v", expected, actual)
+	}
+}
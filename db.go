@@ -2,8 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	elastic "gopkg.in/olivere/elastic.v5"
 )
 
@@ -11,12 +19,105 @@ type ElasticsearchClient struct {
 	raw *elastic.Client
 }
 
+// Document is the source of a document with its metadata.
+type Document struct {
+	Source []byte
+	Meta   DocumentMeta
+}
+
+// DocumentMeta is the metadata of a document, which is unknown if it is nil.
+type DocumentMeta struct {
+	Index       string   `json:"_index"`
+	Type        string   `json:"_type,omitempty"`
+	ID          string   `json:"_id"`
+	Version     *int64   `json:"_version,omitempty"`
+	SeqNo       *int64   `json:"_seq_no,omitempty"`
+	PrimaryTerm *int64   `json:"_primary_term,omitempty"`
+	Routing     string   `json:"_routing,omitempty"`
+	Score       *float64 `json:"_score,omitempty"`
+}
+
+// rawSorter is an element of the sort DSL as it is.
+type rawSorter json.RawMessage
+
+func (s rawSorter) Source() (interface{}, error) {
+	return json.RawMessage(s), nil
+}
+
 func DeserializeDRLs(urls string) []string {
 	return strings.Split(urls, ",")
 }
 
-func NewElasticsearchClient(urls []string) (*ElasticsearchClient, error) {
-	raw, err := elastic.NewClient(elastic.SetURL(urls...))
+// ClientConfig is the configuration to connect to the Elasticsearch cluster.
+type ClientConfig struct {
+	URLs []string
+
+	// The credentials, of which only one kind can be given
+	Username    string
+	Password    string
+	APIKey      string
+	BearerToken string
+
+	// The files of the certificates and the key in PEM
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+
+	// Sniffing finds the nodes of the cluster, which are often unreachable behind proxies or in containers
+	Sniff       bool
+	Healthcheck bool
+	Timeout     time.Duration // The timeout of each request, or 0 for no timeout
+	MaxRetries  int
+}
+
+func NewElasticsearchClient(config ClientConfig) (*ElasticsearchClient, error) {
+	options := []elastic.ClientOptionFunc{elastic.SetURL(config.URLs...)}
+	if strings.HasPrefix(config.URLs[0], "https://") {
+		// The nodes found by sniffing are connected by the same scheme
+		options = append(options, elastic.SetScheme("https"))
+	}
+	options = append(options, elastic.SetHealthcheck(config.Healthcheck), elastic.SetRetrier(newRetrier(config.MaxRetries)))
+	if config.Timeout > 0 {
+		options = append(options, elastic.SetSnifferTimeoutStartup(config.Timeout), elastic.SetHealthcheckTimeoutStartup(config.Timeout))
+	}
+	if config.Username != "" {
+		options = append(options, elastic.SetBasicAuth(config.Username, config.Password))
+	}
+
+	// API keys and bearer tokens are not supported by the client, so the header is set by the transport
+	var authorization string
+	if config.APIKey != "" {
+		authorization = "ApiKey " + config.APIKey
+	}
+	if config.BearerToken != "" {
+		authorization = "Bearer " + config.BearerToken
+	}
+
+	// Build the HTTP client only if the default one does not work
+	tlsConfig, err := NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	var transport http.RoundTripper = http.DefaultTransport
+	if tlsConfig != nil {
+		tlsTransport := http.DefaultTransport.(*http.Transport).Clone()
+		tlsTransport.TLSClientConfig = tlsConfig
+		transport = tlsTransport
+	}
+	if authorization != "" {
+		transport = &authTransport{base: transport, authorization: authorization}
+	}
+	if transport != http.DefaultTransport || config.Timeout > 0 {
+		options = append(options, elastic.SetHttpClient(&http.Client{Transport: transport, Timeout: config.Timeout}))
+	}
+
+	// Connect to the given URLs only, if the nodes found by sniffing are not available
+	raw, err := elastic.NewClient(append(options, elastic.SetSniff(config.Sniff))...)
+	if err != nil && config.Sniff && isSniffError(err) {
+		log.Printf("Failed to sniff the nodes, so connect without sniffing: err=%v\n", err)
+		raw, err = elastic.NewClient(append(options, elastic.SetSniff(false))...)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -25,24 +126,185 @@ func NewElasticsearchClient(urls []string) (*ElasticsearchClient, error) {
 	return &c, nil
 }
 
+// The wait before the first retry, which is doubled for each of the following retries up to the maximum
+const (
+	retryInitialWait = 100 * time.Millisecond
+	retryMaxWait     = 8 * time.Second
+)
+
+// newRetrier returns the retrier of the failed requests, which retries them up to the times with the exponential backoff.
+func newRetrier(maxRetries int) elastic.Retrier {
+	// The client counts the retries from 1, so the first wait is never used
+	ticks := make([]int, maxRetries+1)
+	wait := retryInitialWait
+	for i := 1; i < len(ticks); i++ {
+		ticks[i] = int(wait / time.Millisecond)
+		wait *= 2
+		if wait > retryMaxWait {
+			wait = retryMaxWait
+		}
+	}
+	return elastic.NewBackoffRetrier(elastic.NewSimpleBackoff(ticks...).Jitter(true))
+}
+
+// isSniffError reports whether the client failed to sniff the nodes, or to reach any of the nodes found by sniffing.
+// The client wraps all of the errors to connect into ErrNoClient, so they are told by the messages.
+// The failed health checks of the given URLs are not the errors of sniffing, since they are done before it.
+func isSniffError(err error) bool {
+	if errors.Cause(err) != elastic.ErrNoClient {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "sniff timeout") || strings.Contains(message, "no active connection found")
+}
+
+// ClusterVersion is the version of the software which runs the cluster.
+type ClusterVersion struct {
+	Number       string `json:"number"`
+	Distribution string `json:"distribution"`
+}
+
+// MajorVersion returns the major version number, or 0 if it is unknown.
+func (v ClusterVersion) MajorVersion() int {
+	major, _ := strconv.Atoi(strings.SplitN(v.Number, ".", 2)[0])
+	return major
+}
+
+// MinorVersion returns the minor version number, or 0 if it is unknown.
+func (v ClusterVersion) MinorVersion() int {
+	elems := strings.SplitN(v.Number, ".", 3)
+	if len(elems) < 2 {
+		return 0
+	}
+	minor, _ := strconv.Atoi(elems[1])
+	return minor
+}
+
+func (c *ElasticsearchClient) GetVersion() (ClusterVersion, error) {
+	res, err := c.raw.PerformRequest(context.Background(), "GET", "/", nil, nil)
+	if err != nil {
+		return ClusterVersion{}, err
+	}
+	var info struct {
+		Version ClusterVersion `json:"version"`
+	}
+	err = json.Unmarshal(res.Body, &info)
+	if err != nil {
+		return ClusterVersion{}, err
+	}
+	return info.Version, nil
+}
+
+// IndexStats is the summary of an index from its settings and statistics.
+type IndexStats struct {
+	UUID             string
+	CreationDate     string
+	NumberOfShards   string
+	NumberOfReplicas string
+	DocCount         int64
+	DeletedDocCount  int64
+	StoreSize        int64
+}
+
+// GetIndexStats requests the settings and the statistics of the index directly, since their formats are stable across the versions.
+func (c *ElasticsearchClient) GetIndexStats(index string) (IndexStats, error) {
+	var stats IndexStats
+	path := "/" + url.PathEscape(index)
+	res, err := c.raw.PerformRequest(context.Background(), "GET", path+"/_settings", nil, nil)
+	if err != nil {
+		return stats, err
+	}
+	var settings map[string]struct {
+		Settings struct {
+			Index struct {
+				UUID             string `json:"uuid"`
+				CreationDate     string `json:"creation_date"`
+				NumberOfShards   string `json:"number_of_shards"`
+				NumberOfReplicas string `json:"number_of_replicas"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	err = json.Unmarshal(res.Body, &settings)
+	if err != nil {
+		return stats, err
+	}
+	indexSettings := settings[index].Settings.Index
+	stats.UUID = indexSettings.UUID
+	stats.CreationDate = indexSettings.CreationDate
+	stats.NumberOfShards = indexSettings.NumberOfShards
+	stats.NumberOfReplicas = indexSettings.NumberOfReplicas
+
+	res, err = c.raw.PerformRequest(context.Background(), "GET", path+"/_stats/docs,store", nil, nil)
+	if err != nil {
+		return stats, err
+	}
+	var indexStats struct {
+		Indices map[string]struct {
+			Primaries struct {
+				Docs struct {
+					Count   int64 `json:"count"`
+					Deleted int64 `json:"deleted"`
+				} `json:"docs"`
+				Store struct {
+					SizeInBytes int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"primaries"`
+		} `json:"indices"`
+	}
+	err = json.Unmarshal(res.Body, &indexStats)
+	if err != nil {
+		return stats, err
+	}
+	primaries := indexStats.Indices[index].Primaries
+	stats.DocCount = primaries.Docs.Count
+	stats.DeletedDocCount = primaries.Docs.Deleted
+	stats.StoreSize = primaries.Store.SizeInBytes
+	return stats, nil
+}
+
+// IndexMetadata is the definition of an index, in the same layouts as the responses of the APIs to get each of them.
+type IndexMetadata struct {
+	Aliases  json.RawMessage `json:"aliases"`
+	Mappings json.RawMessage `json:"mappings"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+// GetIndexMetadata requests the aliases, the mappings and the settings of the index at once.
+// The mappings are keyed by the document types unless the indices have no types.
+func (c *ElasticsearchClient) GetIndexMetadata(index string) (IndexMetadata, error) {
+	res, err := c.raw.PerformRequest(context.Background(), "GET", "/"+url.PathEscape(index), nil, nil)
+	if err != nil {
+		return IndexMetadata{}, err
+	}
+	var metadata map[string]IndexMetadata
+	err = json.Unmarshal(res.Body, &metadata)
+	if err != nil {
+		return IndexMetadata{}, err
+	}
+	indexMetadata, ok := metadata[index]
+	if !ok {
+		return IndexMetadata{}, fmt.Errorf("the index is not found in the response: index=%v", index)
+	}
+	return indexMetadata, nil
+}
+
 func (c *ElasticsearchClient) GetIndexNames() ([]string, error) {
 	return c.raw.IndexNames()
 }
 
 func (c *ElasticsearchClient) GetDocumentTypes(index string) ([]string, error) {
-	mappings, err := c.raw.GetMapping().Do(context.Background())
+	metadata, err := c.GetIndexMetadata(index)
+	if err != nil {
+		return nil, err
+	}
+	var mappings map[string]json.RawMessage
+	err = json.Unmarshal(metadata.Mappings, &mappings)
 	if err != nil {
 		return nil, err
 	}
 	var dtypes []string
-	for curIndex, curMappings := range mappings {
-		if curIndex == index {
-			mappingsByIndex := curMappings.(map[string]interface{})["mappings"].(map[string]interface{})
-			for dtype := range mappingsByIndex {
-				dtypes = append(dtypes, dtype)
-			}
-			break
-		}
+	for dtype := range mappings {
+		dtypes = append(dtypes, dtype)
 	}
 	return dtypes, nil
 }
@@ -55,18 +317,181 @@ func (c *ElasticsearchClient) CountDocuments(index string, dtype string) (int64,
 	return result.Hits.TotalHits, nil
 }
 
-func (c *ElasticsearchClient) GetDocuments(index string, dtype string, from int, size int) (map[string][]byte, error) {
-	docs := make(map[string][]byte)
-	result, err := c.raw.Search().Index(index).Type(dtype).From(from).Size(size).Do(context.Background())
-	if err != nil {
-		return nil, err
+// CountMatchingDocuments counts the documents matching the query DSL in the index, or all of them if the query is nil.
+func (c *ElasticsearchClient) CountMatchingDocuments(index string, query []byte) (int64, error) {
+	service := c.raw.Count(index)
+	if query != nil {
+		service = service.Query(elastic.RawStringQuery(query))
 	}
-	for _, hit := range result.Hits.Hits {
-		docSource, err := hit.Source.MarshalJSON()
+	return service.Do(context.Background())
+}
+
+// GetDocuments searches the documents by the query DSL, and orders them by the sort DSL which is a JSON array.
+// If the document type is empty, the documents of all types are searched, and if the query is nil, all documents match.
+func (c *ElasticsearchClient) GetDocuments(index string, dtype string, query []byte, sort []byte, from int, size int) (map[string]Document, error) {
+	service := c.raw.Search().Index(index).From(from).Size(size).Version(true)
+	if dtype != "" {
+		service = service.Type(dtype)
+	}
+	if query != nil {
+		service = service.Query(elastic.RawStringQuery(query))
+	}
+	if sort != nil {
+		var sorters []json.RawMessage
+		err := json.Unmarshal(sort, &sorters)
 		if err != nil {
 			return nil, err
 		}
-		docs[hit.Id] = docSource
+		for _, sorter := range sorters {
+			service = service.SortBy(rawSorter(sorter))
+		}
+	}
+	result, err := service.Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	docs, _, err := decodeHits(result.Hits.Hits, true)
+	return docs, err
+}
+
+// The maximum number of documents reachable by from and size, which is the default of index.max_result_window
+const maxResultWindow = 10000
+
+// The sort to page the documents stably, whose values are unique to search after them
+var pagingSort = elastic.NewFieldSort("_uid").Asc()
+
+// GetPagedDocuments returns a page of the documents ordered stably, with the sort values of the last document.
+// The page starts after the sort values if they are given, otherwise at the offset which must be within maxResultWindow.
+// If the source is not fetched, the documents have neither the sources nor the versions to only walk through them.
+func (c *ElasticsearchClient) GetPagedDocuments(index string, dtype string, from int, searchAfter []interface{}, size int, fetchSource bool) (map[string]Document, []interface{}, error) {
+	service := c.raw.Search().Index(index).Type(dtype).SortBy(pagingSort).Size(size).FetchSource(fetchSource).Version(fetchSource)
+	if searchAfter != nil {
+		service = service.SearchAfter(searchAfter...)
+	} else {
+		service = service.From(from)
+	}
+	result, err := service.Do(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodeHits(result.Hits.Hits, fetchSource)
+}
+
+// decodeHits maps the IDs of the hits to the documents, and returns them with the sort values of the last hit.
+func decodeHits(hits []*elastic.SearchHit, fetchSource bool) (map[string]Document, []interface{}, error) {
+	docs := make(map[string]Document)
+	var lastSort []interface{}
+	for _, hit := range hits {
+		var doc Document
+		if fetchSource && hit.Source != nil {
+			var err error
+			doc.Source, err = hit.Source.MarshalJSON()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		doc.Meta = DocumentMeta{Index: hit.Index, Type: hit.Type, ID: hit.Id, Version: hit.Version, Routing: hit.Routing, Score: hit.Score}
+		docs[hit.Id] = doc
+		lastSort = hit.Sort
+	}
+	return docs, lastSort, nil
+}
+
+// IndexDocument indexes the document source and returns the metadata of the document.
+// If the ID is empty, Elasticsearch generates a new one, and if the routing is empty, the document is routed by its ID.
+func (c *ElasticsearchClient) IndexDocument(index string, dtype string, id string, routing string, source []byte) (DocumentMeta, error) {
+	service := c.raw.Index().Index(index).Type(dtype).BodyString(string(source)).Refresh("true")
+	if id != "" {
+		service = service.Id(id)
+	}
+	if routing != "" {
+		service = service.Routing(routing)
+	}
+	result, err := service.Do(context.Background())
+	if err != nil {
+		return DocumentMeta{}, err
+	}
+	meta := DocumentMeta{Index: result.Index, Type: result.Type, ID: result.Id, Version: &result.Version, Routing: routing}
+	if result.PrimaryTerm != 0 {
+		// Elasticsearch 5 has no sequence numbers
+		meta.SeqNo = &result.SeqNo
+		meta.PrimaryTerm = &result.PrimaryTerm
+	}
+	return meta, nil
+}
+
+// UpdateDocument merges the partial document into the document by the Update API, and returns the updated document.
+func (c *ElasticsearchClient) UpdateDocument(index string, dtype string, id string, routing string, partial []byte) (Document, error) {
+	path := "/" + url.PathEscape(index) + "/" + url.PathEscape(dtype) + "/" + url.PathEscape(id) + "/_update"
+	return c.updateDocument(path, routing, partial)
+}
+
+// updateDocument requests the Update API directly, because its response has the sequence numbers which the client for Elasticsearch 5 does not know.
+// The updated source is returned in the response, so that it need not be searched again.
+func (c *ElasticsearchClient) updateDocument(path string, routing string, partial []byte) (Document, error) {
+	params := url.Values{"refresh": []string{"true"}}
+	if routing != "" {
+		params.Set("routing", routing)
+	}
+	body := map[string]interface{}{"doc": json.RawMessage(partial), "_source": true}
+	res, err := c.raw.PerformRequest(context.Background(), "POST", path, params, body)
+	if err != nil {
+		return Document{}, err
+	}
+	var result struct {
+		DocumentMeta
+		Get struct {
+			Source  json.RawMessage `json:"_source"`
+			Routing string          `json:"_routing"`
+		} `json:"get"`
 	}
-	return docs, nil
+	err = json.Unmarshal(res.Body, &result)
+	if err != nil {
+		return Document{}, err
+	}
+	meta := result.DocumentMeta
+	meta.Routing = result.Get.Routing
+	return Document{Source: result.Get.Source, Meta: meta}, nil
+}
+
+func (c *ElasticsearchClient) DeleteDocument(index string, dtype string, id string, routing string) error {
+	service := c.raw.Delete().Index(index).Type(dtype).Id(id).Refresh("true")
+	if routing != "" {
+		service = service.Routing(routing)
+	}
+	_, err := service.Do(context.Background())
+	return err
+}
+
+func (c *ElasticsearchClient) DeleteIndex(index string) error {
+	_, err := c.raw.DeleteIndex(index).Do(context.Background())
+	return err
+}
+
+// CreateIndex creates the index by the body of its settings, mappings and aliases, or with the defaults if the body is nil.
+func (c *ElasticsearchClient) CreateIndex(index string, body []byte) error {
+	service := c.raw.CreateIndex(index)
+	if body != nil {
+		service = service.BodyString(string(body))
+	}
+	_, err := service.Do(context.Background())
+	return err
+}
+
+func (c *ElasticsearchClient) PutMapping(index string, dtype string, mapping []byte) error {
+	_, err := c.raw.PutMapping().Index(index).Type(dtype).BodyString(string(mapping)).Do(context.Background())
+	return err
+}
+
+// PutSettings updates the dynamic settings of the index.
+func (c *ElasticsearchClient) PutSettings(index string, settings []byte) error {
+	_, err := c.raw.IndexPutSettings(index).BodyString(string(settings)).Do(context.Background())
+	return err
+}
+
+// UpdateAliases applies the actions to add and remove the aliases at once.
+func (c *ElasticsearchClient) UpdateAliases(actions []byte) error {
+	body := map[string]interface{}{"actions": json.RawMessage(actions)}
+	_, err := c.raw.PerformRequest(context.Background(), "POST", "/_aliases", nil, body)
+	return err
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/pkg/errors"
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+// The statuses which are not predefined by the fuse package
+const (
+	ETIMEDOUT = fuse.Status(syscall.ETIMEDOUT)
+	EISDIR    = fuse.Status(syscall.EISDIR)
+)
+
+// errorStatus translates the error from Elasticsearch into the FUSE status.
+// The handlers return it to the kernel instead of stopping the server, so a failed query fails only the file operation.
+func errorStatus(err error) fuse.Status {
+	if err == nil {
+		return fuse.OK
+	}
+	cause := errors.Cause(err)
+
+	// Errors returned from Elasticsearch with HTTP status codes
+	if e, ok := cause.(*elastic.Error); ok {
+		switch {
+		case e.Status == http.StatusNotFound:
+			return fuse.ENOENT
+		case e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden:
+			return fuse.EACCES
+		case e.Status == http.StatusRequestTimeout || e.Status == http.StatusGatewayTimeout:
+			return ETIMEDOUT
+		case e.Details != nil && strings.HasSuffix(e.Details.Type, "already_exists_exception"):
+			return fuse.Status(syscall.EEXIST)
+		case e.Status == http.StatusBadRequest:
+			return fuse.EINVAL
+		}
+		return fuse.EIO
+	}
+
+	// Errors on the way to Elasticsearch
+	if cause == context.DeadlineExceeded {
+		return ETIMEDOUT
+	}
+	if e, ok := cause.(net.Error); ok && e.Timeout() {
+		return ETIMEDOUT
+	}
+	return fuse.EIO
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+type BufferFile struct {
+	nodefs.File
+
+	mu     sync.Mutex
+	data   []byte
+	dirty  bool
+	commit func(data []byte) fuse.Status
+}
+
+// NewBufferFile returns a file which keeps its whole content in memory.
+// The initial data is copied, and written data is passed to the commit function when the file is flushed.
+func NewBufferFile(data []byte, commit func(data []byte) fuse.Status) *BufferFile {
+	var f BufferFile
+	f.File = nodefs.NewDefaultFile()
+	f.data = append([]byte(nil), data...)
+	f.commit = commit
+	return &f
+}
+
+func (f *BufferFile) String() string {
+	return "BufferFile"
+}
+
+func (f *BufferFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off >= int64(len(f.data)) {
+		return fuse.ReadResultData(nil), fuse.OK
+	}
+	end := off + int64(len(buf))
+	if end > int64(len(f.data)) {
+		end = int64(len(f.data))
+	}
+	data := make([]byte, end-off)
+	copy(data, f.data[off:end])
+	return fuse.ReadResultData(data), fuse.OK
+}
+
+func (f *BufferFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := off + int64(len(data))
+	if end > int64(len(f.data)) {
+		grown := make([]byte, end)
+		copy(grown, f.data)
+		f.data = grown
+	}
+	copy(f.data[off:end], data)
+	f.dirty = true
+	return uint32(len(data)), fuse.OK
+}
+
+func (f *BufferFile) Truncate(size uint64) fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size <= uint64(len(f.data)) {
+		f.data = f.data[:size]
+	} else {
+		grown := make([]byte, size)
+		copy(grown, f.data)
+		f.data = grown
+	}
+	f.dirty = true
+	return fuse.OK
+}
+
+func (f *BufferFile) GetAttr(out *fuse.Attr) fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = uint64(len(f.data))
+	return fuse.OK
+}
+
+// Utimens accepts the times but ignores them, since the documents have no times to keep.
+func (f *BufferFile) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
+	return fuse.OK
+}
+
+func (f *BufferFile) Flush() fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.dirty {
+		return fuse.OK
+	}
+	code := f.commit(f.data)
+	if code.Ok() {
+		f.dirty = false
+	}
+	return code
+}
+
+func (f *BufferFile) Fsync(flags int) fuse.Status {
+	return f.Flush()
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// With --flatten, the document in a paging directory is the directory of its fields.
+// The objects and the arrays in the source are the subdirectories, and the other values are the files of them.
+// The whole documents are still shown by the file names with the suffixes of the formats, such as <id>.json.
+// The handlers below take the path of a field as its keys and array indices under the document, like [user email] or [tags 0].
+
+// isFieldPath reports whether the path is under the directory of a document.
+func (fs *ElasticsearchFS) isFieldPath(nameElems []string) bool {
+	return fs.flatten && len(nameElems) >= 5
+}
+
+// ensureDocument returns the document in the page.
+func (fs *ElasticsearchFS) ensureDocument(index string, dtype string, pageName string, id string) (Document, fuse.Status) {
+	page, err := strconv.Atoi(pageName)
+	if err != nil || page < 0 {
+		return Document{}, fuse.ENOENT
+	}
+	docs, err := fs.cache.EnsureDocuments(index, dtype, page)
+	if err != nil {
+		log.Printf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", index, dtype, page, err)
+		return Document{}, errorStatus(err)
+	}
+	doc, ok := docs[id]
+	if !ok {
+		return Document{}, fuse.ENOENT
+	}
+	return doc, fuse.OK
+}
+
+// ensureFields returns the source of the document in the page, decoded with the numbers as they are.
+func (fs *ElasticsearchFS) ensureFields(index string, dtype string, pageName string, id string) (interface{}, fuse.Status) {
+	doc, code := fs.ensureDocument(index, dtype, pageName, id)
+	if !code.Ok() {
+		return nil, code
+	}
+	source, err := decodeFields(doc.Source)
+	if err != nil {
+		log.Printf("Failed to decode the doc: index=%v, dtype=%v, id=%v, err=%v\n", index, dtype, id, err)
+		return nil, fuse.EIO
+	}
+	return source, fuse.OK
+}
+
+func (fs *ElasticsearchFS) getFieldAttr(index string, dtype string, pageName string, id string, nameElems []string) (*fuse.Attr, fuse.Status) {
+	source, code := fs.ensureFields(index, dtype, pageName, id)
+	if !code.Ok() {
+		return nil, code
+	}
+	value, ok := lookupField(source, nameElems)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
+	}
+	return &fuse.Attr{Mode: fuse.S_IFREG | 0644, Size: uint64(len(formatField(value)))}, fuse.OK
+}
+
+func (fs *ElasticsearchFS) openFieldDir(index string, dtype string, pageName string, id string, nameElems []string) ([]fuse.DirEntry, fuse.Status) {
+	source, code := fs.ensureFields(index, dtype, pageName, id)
+	if !code.Ok() {
+		return nil, code
+	}
+	value, ok := lookupField(source, nameElems)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	var entries []fuse.DirEntry
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, elem := range v {
+			if key == "" || strings.Contains(key, "/") {
+				// The fields which cannot be the file names are shown only in the whole documents
+				continue
+			}
+			entries = append(entries, fuse.DirEntry{Name: key, Mode: fieldMode(elem)})
+		}
+	case []interface{}:
+		for i, elem := range v {
+			entries = append(entries, fuse.DirEntry{Name: strconv.Itoa(i), Mode: fieldMode(elem)})
+		}
+	default:
+		return nil, fuse.ENOTDIR
+	}
+	return entries, fuse.OK
+}
+
+func (fs *ElasticsearchFS) openFieldFile(index string, dtype string, pageName string, id string, nameElems []string, flags uint32) (nodefs.File, fuse.Status) {
+	source, code := fs.ensureFields(index, dtype, pageName, id)
+	if !code.Ok() {
+		return nil, code
+	}
+	value, ok := lookupField(source, nameElems)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	if fieldMode(value) == fuse.S_IFDIR {
+		return nil, EISDIR
+	}
+	if flags&fuse.O_ANYWRITE != 0 {
+		return NewBufferFile(formatField(value), fs.commitField(index, dtype, pageName, id, nameElems)), fuse.OK
+	}
+	return nodefs.NewDataFile(formatField(value)), fuse.OK
+}
+
+func (fs *ElasticsearchFS) truncateField(index string, dtype string, pageName string, id string, nameElems []string, size uint64) fuse.Status {
+	file, code := fs.openFieldFile(index, dtype, pageName, id, nameElems, fuse.O_ANYWRITE)
+	if !code.Ok() {
+		return code
+	}
+	code = file.Truncate(size)
+	if !code.Ok() {
+		return code
+	}
+	return file.Flush()
+}
+
+// createField returns the file of a new field in the object, which is saved as a string unless it is written as a JSON scalar.
+func (fs *ElasticsearchFS) createField(index string, dtype string, pageName string, id string, nameElems []string) (nodefs.File, fuse.Status) {
+	source, code := fs.ensureFields(index, dtype, pageName, id)
+	if !code.Ok() {
+		return nil, code
+	}
+	parent, ok := lookupField(source, nameElems[:len(nameElems)-1])
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	_, ok = parent.(map[string]interface{})
+	if !ok {
+		// The elements cannot be added to the arrays, since the partial update replaces them as a whole
+		return nil, fuse.EPERM
+	}
+	// The new field is saved only if it is written, so the probe and swap files of editors never become fields
+	return NewBufferFile(nil, fs.commitField(index, dtype, pageName, id, nameElems)), fuse.OK
+}
+
+// commitField returns the function to save the written value of the field by the partial update of the document.
+// The update has the whole top-level field which has the value, because the elements of arrays cannot be updated partially.
+func (fs *ElasticsearchFS) commitField(index string, dtype string, pageName string, id string, nameElems []string) func([]byte) fuse.Status {
+	return func(data []byte) fuse.Status {
+		// The source is taken again, since the other fields may have been updated after the file is opened
+		doc, code := fs.ensureDocument(index, dtype, pageName, id)
+		if !code.Ok() {
+			return code
+		}
+		source, err := decodeFields(doc.Source)
+		if err != nil {
+			log.Printf("Failed to decode the doc: index=%v, dtype=%v, id=%v, err=%v\n", index, dtype, id, err)
+			return fuse.EIO
+		}
+		parent, ok := lookupField(source, nameElems[:len(nameElems)-1])
+		if !ok {
+			return fuse.ENOENT
+		}
+		key := nameElems[len(nameElems)-1]
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			old, ok := p[key]
+			p[key] = parseField(data, old, ok)
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(p) {
+				return fuse.ENOENT
+			}
+			p[i] = parseField(data, p[i], true)
+		default:
+			return fuse.ENOTDIR
+		}
+
+		top := nameElems[0]
+		partial, err := json.Marshal(map[string]interface{}{top: source.(map[string]interface{})[top]})
+		if err != nil {
+			return fuse.EIO
+		}
+		err = fs.cache.UpdateDocument(index, dtype, id, doc.Meta.Routing, partial)
+		if err != nil {
+			log.Printf("Failed to update the doc: index=%v, dtype=%v, id=%v, field=%v, err=%v\n", index, dtype, id, strings.Join(nameElems, "."), err)
+			return errorStatus(err)
+		}
+		return fuse.OK
+	}
+}
+
+// decodeFields decodes the source of a document, keeping the numbers as they are written.
+func decodeFields(source []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	_, err = decoder.Token()
+	if err != io.EOF {
+		return nil, errors.New("trailing data after the value")
+	}
+	return value, nil
+}
+
+// lookupField follows the path through the objects by the keys and through the arrays by the indices.
+func lookupField(value interface{}, path []string) (interface{}, bool) {
+	for _, name := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			elem, ok := v[name]
+			if !ok {
+				return nil, false
+			}
+			value = elem
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func fieldMode(value interface{}) uint32 {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return fuse.S_IFDIR
+	}
+	return fuse.S_IFREG
+}
+
+// formatField returns the content of the field file, which is the string without quotes or the JSON of the other value, with a newline.
+func formatField(value interface{}) []byte {
+	s, ok := value.(string)
+	if ok {
+		return []byte(s + "\n")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return append(data, '\n')
+}
+
+// parseField returns the value of the written content of the field file, without the trailing newline.
+// The strings are kept as strings, and the other fields are parsed as JSON scalars if possible, or saved as strings.
+func parseField(data []byte, old interface{}, exists bool) interface{} {
+	text := strings.TrimSuffix(string(data), "\n")
+	_, isString := old.(string)
+	if exists && isString {
+		return text
+	}
+	value, err := decodeFields([]byte(text))
+	if err != nil || fieldMode(value) == fuse.S_IFDIR {
+		return text
+	}
+	return value
+}
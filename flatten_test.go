@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLookupField(t *testing.T) {
+	source, err := decodeFields([]byte(`{"user":{"email":"a@example.com","age":30},"tags":["x",{"name":"y"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path     []string
+		expected interface{}
+		ok       bool
+	}{
+		{[]string{"user", "email"}, "a@example.com", true},
+		{[]string{"user", "age"}, json.Number("30"), true},
+		{[]string{"tags", "0"}, "x", true},
+		{[]string{"tags", "1", "name"}, "y", true},
+		{[]string{"tags", "2"}, nil, false},
+		{[]string{"tags", "-1"}, nil, false},
+		{[]string{"tags", "name"}, nil, false},
+		{[]string{"user", "email", "domain"}, nil, false},
+		{[]string{"missing"}, nil, false},
+	} {
+		value, ok := lookupField(source, c.path)
+		if ok != c.ok || !reflect.DeepEqual(value, c.expected) {
+			t.Errorf("path=%v, expected=%v, actual=%v, ok=%v", c.path, c.expected, value, ok)
+		}
+	}
+}
+
+func TestParseField(t *testing.T) {
+	for _, c := range []struct {
+		data     string
+		old      interface{}
+		exists   bool
+		expected interface{}
+	}{
+		// The strings stay strings even if they look like the other values
+		{"42\n", "7", true, "42"},
+		{"true\n", "false", true, "true"},
+		// The other fields are parsed as the JSON scalars
+		{"42\n", json.Number("7"), true, json.Number("42")},
+		{"false\n", true, true, false},
+		{"null\n", json.Number("7"), true, nil},
+		// The values which are not scalars are saved as strings
+		{"{\"a\":1}\n", json.Number("7"), true, "{\"a\":1}"},
+		{"hello\n", json.Number("7"), true, "hello"},
+		// The new fields are parsed in the same way
+		{"3.5", nil, false, json.Number("3.5")},
+		{"text", nil, false, "text"},
+	} {
+		value := parseField([]byte(c.data), c.old, c.exists)
+		if !reflect.DeepEqual(value, c.expected) {
+			t.Errorf("data=%q, old=%v, expected=%#v, actual=%#v", c.data, c.old, c.expected, value)
+		}
+	}
+}
+
+func TestFormatField(t *testing.T) {
+	for _, c := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{"a@example.com", "a@example.com\n"},
+		{json.Number("30"), "30\n"},
+		{true, "true\n"},
+		{nil, "null\n"},
+	} {
+		data := formatField(c.value)
+		if string(data) != c.expected {
+			t.Errorf("value=%v, expected=%q, actual=%q", c.value, c.expected, data)
+		}
+		// The formatted field is parsed back into the same value
+		value := parseField(data, c.value, true)
+		if !reflect.DeepEqual(value, c.value) {
+			t.Errorf("the field is changed by the round trip: value=%#v, parsed=%#v", c.value, value)
+		}
+	}
+}
+
+func TestDecodeFieldsTrailingData(t *testing.T) {
+	_, err := decodeFields([]byte(`{"a":1} {"b":2}`))
+	if err == nil {
+		t.Error("the trailing data is accepted")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "sync"
+
+// flightGroup deduplicates the concurrent calls with the same key.
+// While a call is in flight, the other callers of the key wait for it and share its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+func (g *flightGroup) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call, ok := g.calls[key]
+	if ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call = new(flightCall)
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.value, call.err = fn()
+	call.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return call.value, call.err
+}
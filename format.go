@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The formats to show the sources of the documents
+const (
+	jsonFormat   = "json"   // As stored in Elasticsearch
+	prettyFormat = "pretty" // Indented JSON
+	yamlFormat   = "yaml"
+	ndjsonFormat = "ndjson" // JSON in a line
+)
+
+// The suffixes of the file names to show the documents in the other formats than the one of the mount
+var formatSuffixes = map[string]string{
+	".json":   jsonFormat,
+	".yaml":   yamlFormat,
+	".yml":    yamlFormat,
+	".ndjson": ndjsonFormat,
+}
+
+// IsValidFormat reports whether the format is known.
+func IsValidFormat(format string) bool {
+	switch format {
+	case jsonFormat, prettyFormat, yamlFormat, ndjsonFormat:
+		return true
+	}
+	return false
+}
+
+// readDocument finds the document by the file name, which is the document ID or the ID with the suffix of a format,
+// and returns its ID and its source in the format.
+// The document whose ID is the file name itself takes precedence and is shown in the format of the mount, because the IDs may contain dots.
+func (fs *ElasticsearchFS) readDocument(docs map[string]Document, fileName string) (string, []byte, string, fuse.Status) {
+	id, format := fileName, fs.format
+	doc, ok := docs[id]
+	for suffix, suffixFormat := range formatSuffixes {
+		if ok {
+			break
+		}
+		if strings.HasSuffix(fileName, suffix) {
+			id, format = strings.TrimSuffix(fileName, suffix), suffixFormat
+			doc, ok = docs[id]
+		}
+	}
+	if !ok {
+		return "", nil, "", fuse.ENOENT
+	}
+	data, err := FormatSource(doc.Source, format)
+	if err != nil {
+		log.Printf("Failed to format the doc: id=%v, format=%v, err=%v\n", id, format, err)
+		return "", nil, "", fuse.EIO
+	}
+	return id, data, format, fuse.OK
+}
+
+// splitFormatSuffix returns the document ID of the new file without the suffix of a format,
+// and the format by the suffix, or the format of the mount if it has no suffix.
+func (fs *ElasticsearchFS) splitFormatSuffix(fileName string) (string, string) {
+	for suffix, format := range formatSuffixes {
+		if strings.HasSuffix(fileName, suffix) && fileName != suffix {
+			return strings.TrimSuffix(fileName, suffix), format
+		}
+	}
+	return fileName, fs.format
+}
+
+// findDocument finds the document by the file name in the same way as readDocument.
+func (fs *ElasticsearchFS) findDocument(docs map[string]Document, fileName string) (Document, bool) {
+	doc, ok := docs[fileName]
+	if ok {
+		return doc, true
+	}
+	id, _ := fs.splitFormatSuffix(fileName)
+	doc, ok = docs[id]
+	return doc, ok
+}
+
+// FormatSource converts the source of a document in JSON into the format.
+func FormatSource(source []byte, format string) ([]byte, error) {
+	switch format {
+	case prettyFormat:
+		var buf bytes.Buffer
+		err := json.Indent(&buf, source, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	case ndjsonFormat:
+		var buf bytes.Buffer
+		err := json.Compact(&buf, source)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	case yamlFormat:
+		decoder := json.NewDecoder(bytes.NewReader(source))
+		decoder.UseNumber()
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(yamlCompatible(value))
+	}
+	return source, nil
+}
+
+// ParseSource converts the content written in the format back into the source of a document in JSON.
+func ParseSource(data []byte, format string) ([]byte, error) {
+	if format != yamlFormat {
+		return data, nil
+	}
+	var value interface{}
+	err := yaml.Unmarshal(data, &value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonCompatible(value))
+}
+
+// yamlCompatible converts the numbers decoded from JSON into the ones encoded to YAML as numbers, not as strings.
+func yamlCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// The keys are sorted by the YAML encoder
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = yamlCompatible(elem)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, elem := range v {
+			a[i] = yamlCompatible(elem)
+		}
+		return a
+	case json.Number:
+		i, err := v.Int64()
+		if err == nil {
+			return i
+		}
+		f, err := v.Float64()
+		if err == nil {
+			return f
+		}
+		return fmt.Sprint(v)
+	}
+	return value
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// decodeSource decodes the source in JSON with the numbers as they are, to compare the sources regardless of their layouts.
+func decodeSource(t *testing.T, source []byte) interface{} {
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		t.Fatalf("source=%s, err=%v", source, err)
+	}
+	return value
+}
+
+func TestFormatSourceRoundTrip(t *testing.T) {
+	source := []byte(`{"name":"a","n":1,"big":9007199254740993,"f":1.5,"b":true,"none":null,"quoted":"1","nested":{"tags":["x","y"],"empty":{}}}`)
+	for _, format := range []string{jsonFormat, prettyFormat, yamlFormat, ndjsonFormat} {
+		data, err := FormatSource(source, format)
+		if err != nil {
+			t.Fatalf("format=%v, err=%v", format, err)
+		}
+		parsed, err := ParseSource(data, format)
+		if err != nil {
+			t.Fatalf("format=%v, data=%s, err=%v", format, data, err)
+		}
+		if !reflect.DeepEqual(decodeSource(t, parsed), decodeSource(t, source)) {
+			t.Errorf("the source is changed by the round trip: format=%v, data=%s, parsed=%s", format, data, parsed)
+		}
+	}
+}
+
+func TestFormatSource(t *testing.T) {
+	source := []byte(`{"count":1,"id":"1"}`)
+	for format, expected := range map[string]string{
+		jsonFormat:   `{"count":1,"id":"1"}`,
+		prettyFormat: "{\n  \"count\": 1,\n  \"id\": \"1\"\n}\n",
+		ndjsonFormat: "{\"count\":1,\"id\":\"1\"}\n",
+		yamlFormat:   "count: 1\nid: \"1\"\n",
+	} {
+		data, err := FormatSource(source, format)
+		if err != nil {
+			t.Fatalf("format=%v, err=%v", format, err)
+		}
+		if string(data) != expected {
+			t.Errorf("format=%v, expected=%q, actual=%q", format, expected, data)
+		}
+	}
+}
+
+func TestParseSourceMalformed(t *testing.T) {
+	_, err := ParseSource([]byte("a: [b"), yamlFormat)
+	if err == nil {
+		t.Error("the malformed YAML is parsed")
+	}
+}
+
+func TestSplitFormatSuffix(t *testing.T) {
+	fs := &ElasticsearchFS{format: prettyFormat}
+	for fileName, expected := range map[string][2]string{
+		"doc":        {"doc", prettyFormat},
+		"doc.json":   {"doc", jsonFormat},
+		"_new.yaml":  {"_new", yamlFormat},
+		"a.b.ndjson": {"a.b", ndjsonFormat},
+		".json":      {".json", prettyFormat},
+	} {
+		id, format := fs.splitFormatSuffix(fileName)
+		if id != expected[0] || format != expected[1] {
+			t.Errorf("fileName=%v, expected=%v, actual=%v", fileName, expected, []string{id, format})
+		}
+	}
+
+	// The document whose ID has the suffix is found by the ID itself
+	docs := map[string]Document{"a": {Meta: DocumentMeta{ID: "a"}}, "b.json": {Meta: DocumentMeta{ID: "b.json"}}}
+	for fileName, expected := range map[string]string{"a": "a", "a.yaml": "a", "b.json": "b.json"} {
+		doc, ok := fs.findDocument(docs, fileName)
+		if !ok || doc.Meta.ID != expected {
+			t.Errorf("fileName=%v, expected=%v, actual=%v", fileName, expected, doc.Meta.ID)
+		}
+	}
+	_, ok := fs.findDocument(docs, "c.json")
+	if ok {
+		t.Error("the missing document is found")
+	}
+}
@@ -1,31 +1,65 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
 )
 
+const (
+	// The file name without the suffix of a format to create a document whose ID is generated by Elasticsearch, such as _new.json
+	newDocumentName = "_new"
+
+	// The file names to show and apply the mappings, the settings and the aliases of the index
+	mappingFileName  = "_mapping.json"
+	settingsFileName = "_settings.json"
+	aliasesFileName  = "_aliases.json"
+)
+
 type ElasticsearchFS struct {
 	pathfs.FileSystem
 
-	cache *ElasticsearchCache
-	debug bool
+	cache            *ElasticsearchCache
+	searches         SearchRegistry
+	pendingIndices   PendingIndexRegistry
+	queries          map[string]SavedQuery
+	allowDestructive bool
+	format           string
+	flatten          bool
+	debug            bool
+}
+
+// FSOptions are the options of the filesystem of a cluster.
+type FSOptions struct {
+	PageSize         int
+	UpdateInterval   time.Duration
+	Queries          map[string]SavedQuery // The saved queries to mount under _queries
+	AllowDestructive bool
+	Format           string // The format to show the documents in
+	Flatten          bool   // Whether to show the documents as the directories of their fields
+	Debug            bool
 }
 
-func NewElasticsearchFS(urls string, pageSize int, debug bool) (*ElasticsearchFS, error) {
-	cache, err := NewElasticsearchCache(urls, pageSize)
+func NewElasticsearchFS(clientConfig ClientConfig, options FSOptions) (*ElasticsearchFS, error) {
+	cache, err := NewElasticsearchCache(clientConfig, options.PageSize, options.UpdateInterval)
 	if err != nil {
 		return nil, err
 	}
 	var fs ElasticsearchFS
 	fs.FileSystem = pathfs.NewDefaultFileSystem()
 	fs.cache = cache
-	fs.debug = debug
+	fs.queries = options.Queries
+	fs.allowDestructive = options.AllowDestructive
+	fs.format = options.Format
+	fs.flatten = options.Flatten
+	fs.debug = options.Debug
 	return &fs, nil
 }
 
@@ -34,68 +68,98 @@ func (fs *ElasticsearchFS) GetAttr(name string, context *fuse.Context) (*fuse.At
 		log.Printf("GetAttr: name=%v\n", name)
 	}
 
-	// Return the attribute of the root directory
+	// Return the attribute of the root directory, which accepts new indices by mkdir
 	if name == "" {
-		return &fuse.Attr{Mode: fuse.S_IFDIR | 0555}, fuse.OK
+		return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
 	}
 
 	// Return the attribute of the index directory
 	nameElems := strings.Split(name, "/")
+	if nameElems[0] == queriesDirName && len(fs.queries) > 0 {
+		return fs.getQueryAttr(nameElems[1:])
+	}
+	if len(nameElems) >= 2 && nameElems[1] == searchDirName {
+		return fs.getSearchAttr(nameElems[0], nameElems[2:])
+	}
+	if isMetaPath(nameElems) {
+		return fs.getMetaAttr(nameElems[0], nameElems[2:])
+	}
+	if fs.isFieldPath(nameElems) {
+		return fs.getFieldAttr(nameElems[0], nameElems[1], nameElems[2], nameElems[3], nameElems[4:])
+	}
 	if len(nameElems) == 1 {
+		if fs.pendingIndices.Has(nameElems[0]) {
+			return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
+		}
 		indexs, err := fs.cache.EnsureIndexNames()
 		if err != nil {
-			log.Fatalf("Failed to ensure the index names: err=%v\n", err)
+			log.Printf("Failed to ensure the index names: err=%v\n", err)
+			return nil, errorStatus(err)
 		}
 		for _, index := range indexs {
 			if nameElems[0] == index {
-				return &fuse.Attr{Mode: fuse.S_IFDIR | 0555}, fuse.OK
+				return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
 			}
 		}
 	}
 
-	// Return the attributes of the document type directory
+	// Return the attributes of the document type directory, or the files of the index definition
+	if len(nameElems) == 2 && isIndexFileName(nameElems[1]) {
+		data, code := fs.ensureIndexFile(nameElems[0], nameElems[1])
+		if !code.Ok() {
+			return nil, code
+		}
+		return &fuse.Attr{Mode: fuse.S_IFREG | 0644, Size: uint64(len(data))}, fuse.OK
+	}
 	if len(nameElems) == 2 {
 		dtypes, err := fs.cache.EnsureDocumentTypes(nameElems[0])
 		if err != nil {
-			log.Fatalf("Failed to ensure the document types: index=%v, err=%v\n", nameElems[0], err)
+			log.Printf("Failed to ensure the document types: index=%v, err=%v\n", nameElems[0], err)
+			return nil, errorStatus(err)
 		}
 		for _, dtype := range dtypes {
 			if nameElems[1] == dtype {
-				return &fuse.Attr{Mode: fuse.S_IFDIR | 0555}, fuse.OK
+				return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
 			}
 		}
 	}
 
 	// Return the attributes of the paging directory
 	if len(nameElems) == 3 {
-		total, err := fs.cache.EnsureDocumentTotal(nameElems[0], nameElems[1])
-		if err != nil {
-			log.Fatalf("Failed to ensure the docs: index=%v, dtype=%v, err=%v\n", nameElems[0], nameElems[1], err)
-		}
 		page, err := strconv.Atoi(nameElems[2])
+		if err != nil || page < 0 {
+			// Non-numeric names are looked up before the documents are created in the type directory, and negative pages never exist
+			return nil, fuse.ENOENT
+		}
+		total, err := fs.cache.EnsureDocumentTotal(nameElems[0], nameElems[1])
 		if err != nil {
-			log.Fatalf("Failed to parse the paging directory name as integer: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], nameElems[2], err)
+			log.Printf("Failed to ensure the docs: index=%v, dtype=%v, err=%v\n", nameElems[0], nameElems[1], err)
+			return nil, errorStatus(err)
 		}
 		from := int64(fs.cache.pageSize * page)
 		if from < total {
-			return &fuse.Attr{Mode: fuse.S_IFDIR | 0555}, fuse.OK
+			return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
 		}
 	}
 
 	// Return the attributes of the document file
 	if len(nameElems) == 4 {
-		page, err := strconv.ParseInt(nameElems[2], 10, 0)
-		if err != nil {
-			log.Fatalf("Failed to parse the paging directory name as integer: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], nameElems[2], err)
+		page, err := strconv.Atoi(nameElems[2])
+		if err != nil || page < 0 {
+			return nil, fuse.ENOENT
 		}
-		docs, err := fs.cache.EnsureDocuments(nameElems[0], nameElems[1], int(page))
+		docs, err := fs.cache.EnsureDocuments(nameElems[0], nameElems[1], page)
 		if err != nil {
-			log.Fatalf("Failed to ensure the docs: index=%v, dtype=%v, err=%v\n", nameElems[0], nameElems[1], err)
+			log.Printf("Failed to ensure the docs: index=%v, dtype=%v, err=%v\n", nameElems[0], nameElems[1], err)
+			return nil, errorStatus(err)
 		}
-		docSource, ok := docs[nameElems[3]]
-		fileSize := uint64(len(docSource))
-		if ok {
-			return &fuse.Attr{Mode: fuse.S_IFREG | 0444, Size: fileSize}, fuse.OK
+		_, ok := docs[nameElems[3]]
+		if ok && fs.flatten {
+			return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
+		}
+		_, data, _, st := fs.readDocument(docs, nameElems[3])
+		if st.Ok() {
+			return &fuse.Attr{Mode: fuse.S_IFREG | 0644, Size: uint64(len(data))}, fuse.OK
 		}
 	}
 	return nil, fuse.ENOENT
@@ -110,24 +174,56 @@ func (fs *ElasticsearchFS) OpenDir(name string, context *fuse.Context) (entries
 	if name == "" {
 		indexs, err := fs.cache.EnsureIndexNames()
 		if err != nil {
-			log.Fatalf("Failed to ensure the index names: err=%v\n", err)
+			log.Printf("Failed to ensure the index names: err=%v\n", err)
+			return nil, errorStatus(err)
 		}
 		for _, index := range indexs {
 			entries = append(entries, fuse.DirEntry{Name: index, Mode: fuse.S_IFDIR})
 		}
+		for _, index := range fs.pendingIndices.List() {
+			entries = append(entries, fuse.DirEntry{Name: index, Mode: fuse.S_IFDIR})
+		}
+		if len(fs.queries) > 0 {
+			entries = append(entries, fuse.DirEntry{Name: queriesDirName, Mode: fuse.S_IFDIR})
+		}
 		return entries, fuse.OK
 	}
 
 	// If the index directory is opened, list up document types as the directory entries.
 	nameElems := strings.Split(name, "/")
+	if nameElems[0] == queriesDirName && len(fs.queries) > 0 {
+		return fs.openQueryDir(nameElems[1:])
+	}
+	if len(nameElems) >= 2 && nameElems[1] == searchDirName {
+		return fs.openSearchDir(nameElems[0], nameElems[2:])
+	}
+	if isMetaPath(nameElems) {
+		return fs.openMetaDir(nameElems[0], nameElems[2:])
+	}
+	if fs.flatten && len(nameElems) >= 4 {
+		return fs.openFieldDir(nameElems[0], nameElems[1], nameElems[2], nameElems[3], nameElems[4:])
+	}
+	if len(nameElems) == 1 && fs.pendingIndices.Has(nameElems[0]) {
+		// The pending index has only the files of the index definition until it is created by writing them
+		for _, fileName := range indexFileNames {
+			entries = append(entries, fuse.DirEntry{Name: fileName, Mode: fuse.S_IFREG})
+		}
+		return entries, fuse.OK
+	}
 	if len(nameElems) == 1 {
 		dtypes, err := fs.cache.EnsureDocumentTypes(nameElems[0])
 		if err != nil {
-			log.Fatalf("Failed to ensure the document types: index=%v, err=%v\n", nameElems[0], err)
+			log.Printf("Failed to ensure the document types: index=%v, err=%v\n", nameElems[0], err)
+			return nil, errorStatus(err)
 		}
 		for _, dtype := range dtypes {
 			entries = append(entries, fuse.DirEntry{Name: dtype, Mode: fuse.S_IFDIR})
 		}
+		entries = append(entries, fuse.DirEntry{Name: searchDirName, Mode: fuse.S_IFDIR})
+		entries = append(entries, fuse.DirEntry{Name: metaDirName, Mode: fuse.S_IFDIR})
+		for _, fileName := range indexFileNames {
+			entries = append(entries, fuse.DirEntry{Name: fileName, Mode: fuse.S_IFREG})
+		}
 		return entries, fuse.OK
 	}
 
@@ -135,7 +231,8 @@ func (fs *ElasticsearchFS) OpenDir(name string, context *fuse.Context) (entries
 	if len(nameElems) == 2 {
 		total, err := fs.cache.EnsureDocumentTotal(nameElems[0], nameElems[1])
 		if err != nil {
-			log.Fatalf("Failed to ensure the docs: index=%v, dtype=%v, err=%v\n", nameElems[0], nameElems[1], err)
+			log.Printf("Failed to ensure the docs: index=%v, dtype=%v, err=%v\n", nameElems[0], nameElems[1], err)
+			return nil, errorStatus(err)
 		}
 		for i := 0; int64(i*fs.cache.pageSize) < total; i++ {
 			entries = append(entries, fuse.DirEntry{Name: strconv.Itoa(i), Mode: fuse.S_IFREG})
@@ -146,15 +243,20 @@ func (fs *ElasticsearchFS) OpenDir(name string, context *fuse.Context) (entries
 	// If the document type directory is opened, list up docs as the file entries.
 	if len(nameElems) == 3 {
 		page, err := strconv.Atoi(nameElems[2])
-		if err != nil {
-			log.Fatalf("Failed to parse the paging directory name as integer: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], nameElems[2], err)
+		if err != nil || page < 0 {
+			return nil, fuse.ENOENT
 		}
-		docs, err := fs.cache.EnsureDocuments(nameElems[0], nameElems[1], int(page))
+		docs, err := fs.cache.EnsureDocuments(nameElems[0], nameElems[1], page)
 		if err != nil {
-			log.Fatalf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], nameElems[2], err)
+			log.Printf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], nameElems[2], err)
+			return nil, errorStatus(err)
+		}
+		docMode := uint32(fuse.S_IFREG)
+		if fs.flatten {
+			docMode = fuse.S_IFDIR
 		}
 		for docID := range docs {
-			entries = append(entries, fuse.DirEntry{Name: docID, Mode: fuse.S_IFREG})
+			entries = append(entries, fuse.DirEntry{Name: docID, Mode: docMode})
 		}
 		return entries, fuse.OK
 	}
@@ -168,19 +270,277 @@ func (fs *ElasticsearchFS) Open(name string, flags uint32, context *fuse.Context
 	}
 
 	nameElems := strings.Split(name, "/")
+	if nameElems[0] == queriesDirName && len(fs.queries) > 0 {
+		return fs.openQueryFile(nameElems[1:], flags)
+	}
+	if len(nameElems) >= 2 && nameElems[1] == searchDirName {
+		return fs.openSearchFile(nameElems[0], nameElems[2:], flags)
+	}
+	if isMetaPath(nameElems) {
+		return fs.openMetaFile(nameElems[0], nameElems[2:], flags)
+	}
+	if fs.isFieldPath(nameElems) {
+		return fs.openFieldFile(nameElems[0], nameElems[1], nameElems[2], nameElems[3], nameElems[4:], flags)
+	}
+	if len(nameElems) == 2 && isIndexFileName(nameElems[1]) {
+		return fs.openIndexFile(nameElems[0], nameElems[1], flags)
+	}
 	if len(nameElems) == 4 {
 		page, err := strconv.Atoi(nameElems[2])
+		if err != nil || page < 0 {
+			return nil, fuse.ENOENT
+		}
+		docs, err := fs.cache.EnsureDocuments(nameElems[0], nameElems[1], page)
 		if err != nil {
-			log.Fatalf("Failed to parse the paging directory name as integer: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], nameElems[2], err)
+			log.Printf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], page, err)
+			return nil, errorStatus(err)
+		}
+		id, data, format, st := fs.readDocument(docs, nameElems[3])
+		if !st.Ok() {
+			return nil, st
+		}
+		if flags&fuse.O_ANYWRITE != 0 {
+			commit := fs.commitDocument(nameElems[0], nameElems[1], id, docs[id].Meta.Routing, format)
+			return NewBufferFile(data, commit), fuse.OK
+		}
+		return nodefs.NewDataFile(data), fuse.OK
+	}
+	return nil, fuse.ENOENT
+}
+
+func (fs *ElasticsearchFS) Truncate(name string, size uint64, context *fuse.Context) (code fuse.Status) {
+	if fs.debug {
+		log.Printf("Truncate: name=%v, size=%v\n", name, size)
+	}
+
+	nameElems := strings.Split(name, "/")
+	if isMetaPath(nameElems) {
+		return fuse.EPERM
+	}
+	if fs.isFieldPath(nameElems) {
+		return fs.truncateField(nameElems[0], nameElems[1], nameElems[2], nameElems[3], nameElems[4:], size)
+	}
+	if len(nameElems) == 4 {
+		page, err := strconv.Atoi(nameElems[2])
+		if err != nil || page < 0 {
+			return fuse.ENOENT
 		}
 		docs, err := fs.cache.EnsureDocuments(nameElems[0], nameElems[1], page)
 		if err != nil {
-			log.Fatalf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], page, err)
+			log.Printf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], page, err)
+			return errorStatus(err)
+		}
+		id, data, format, st := fs.readDocument(docs, nameElems[3])
+		if !st.Ok() {
+			return st
+		}
+		file := NewBufferFile(data, fs.commitDocument(nameElems[0], nameElems[1], id, docs[id].Meta.Routing, format))
+		code = file.Truncate(size)
+		if !code.Ok() {
+			return code
+		}
+		return file.Flush()
+	}
+	return fuse.EPERM
+}
+
+func (fs *ElasticsearchFS) Create(name string, flags uint32, mode uint32, context *fuse.Context) (file nodefs.File, code fuse.Status) {
+	if fs.debug {
+		log.Printf("Create: name=%v, flags=%x, mode=%o\n", name, flags, mode)
+	}
+
+	nameElems := strings.Split(name, "/")
+	if len(nameElems) >= 2 && nameElems[1] == searchDirName {
+		return fs.createSearchFile(nameElems[0], nameElems[2:])
+	}
+	if isMetaPath(nameElems) {
+		return nil, fuse.EPERM
+	}
+	if fs.isFieldPath(nameElems) {
+		return fs.createField(nameElems[0], nameElems[1], nameElems[2], nameElems[3], nameElems[4:])
+	}
+	if len(nameElems) == 2 {
+		if !isIndexFileName(nameElems[1]) {
+			return nil, fuse.EPERM
 		}
-		docSource, ok := docs[nameElems[3]]
+		return NewBufferFile(nil, fs.commitIndexFile(nameElems[0], nameElems[1])), fuse.OK
+	}
+
+	// New documents are accepted in the document type directory and the paging directories
+	if len(nameElems) != 3 && len(nameElems) != 4 {
+		return nil, fuse.EPERM
+	}
+	if len(nameElems) == 4 {
+		page, err := strconv.Atoi(nameElems[2])
+		if err != nil || page < 0 {
+			return nil, fuse.ENOENT
+		}
+	}
+	dtypes, err := fs.cache.EnsureDocumentTypes(nameElems[0])
+	if err != nil {
+		log.Printf("Failed to ensure the document types: index=%v, err=%v\n", nameElems[0], err)
+		return nil, errorStatus(err)
+	}
+	found := false
+	for _, dtype := range dtypes {
+		if nameElems[1] == dtype {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fuse.ENOENT
+	}
+
+	// The suffix of a format is not a part of the ID, and Elasticsearch generates the document ID for the special file name
+	fileName := nameElems[len(nameElems)-1]
+	id, format := fs.splitFormatSuffix(fileName)
+	if id == newDocumentName {
+		id = ""
+	}
+	// The new file is saved only if it is written, so the probe files created and closed by editors never reach the index
+	return NewBufferFile(nil, fs.commitDocument(nameElems[0], nameElems[1], id, "", format)), fuse.OK
+}
+
+// Utimens accepts the times but ignores them, so that touch(1) works on the files, which are neither created nor changed by it.
+func (fs *ElasticsearchFS) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	if fs.debug {
+		log.Printf("Utimens: name=%v\n", name)
+	}
+	return fuse.OK
+}
+
+func (fs *ElasticsearchFS) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if fs.debug {
+		log.Printf("Mkdir: name=%v, mode=%o\n", name, mode)
+	}
+
+	// Only the index directories and the searches are creatable
+	nameElems := strings.Split(name, "/")
+	if len(nameElems) >= 2 && nameElems[1] == searchDirName {
+		return fs.mkdirSearch(nameElems[0], nameElems[2:])
+	}
+	if len(nameElems) != 1 {
+		return fuse.EPERM
+	}
+	if !isValidIndexName(nameElems[0]) {
+		return fuse.EINVAL
+	}
+
+	// The index is created later by writing _settings.json or _mapping.json,
+	// so that the static settings and the aliases written before are given at its creation
+	indexs, err := fs.cache.EnsureIndexNames()
+	if err != nil {
+		log.Printf("Failed to ensure the index names: err=%v\n", err)
+		return errorStatus(err)
+	}
+	for _, index := range indexs {
+		if nameElems[0] == index {
+			return fuse.Status(syscall.EEXIST)
+		}
+	}
+	if !fs.pendingIndices.Add(nameElems[0]) {
+		return fuse.Status(syscall.EEXIST)
+	}
+	return fuse.OK
+}
+
+func (fs *ElasticsearchFS) Unlink(name string, context *fuse.Context) (code fuse.Status) {
+	if fs.debug {
+		log.Printf("Unlink: name=%v\n", name)
+	}
+
+	nameElems := strings.Split(name, "/")
+	if len(nameElems) >= 2 && nameElems[1] == searchDirName {
+		return fs.removeSearch(nameElems[0], nameElems[2:], false)
+	}
+
+	// Only the document files are removable
+	if !fs.allowDestructive || len(nameElems) != 4 || isMetaPath(nameElems) {
+		return fuse.EPERM
+	}
+	page, err := strconv.Atoi(nameElems[2])
+	if err != nil || page < 0 {
+		return fuse.ENOENT
+	}
+	// The document is looked up for its routing, without which the deletion may go to another shard
+	docs, err := fs.cache.EnsureDocuments(nameElems[0], nameElems[1], page)
+	if err != nil {
+		log.Printf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], page, err)
+		return errorStatus(err)
+	}
+	doc, ok := fs.findDocument(docs, nameElems[3])
+	if !ok {
+		return fuse.ENOENT
+	}
+	err = fs.cache.DeleteDocument(nameElems[0], nameElems[1], doc.Meta.ID, doc.Meta.Routing)
+	if err != nil {
+		log.Printf("Failed to delete the doc: index=%v, dtype=%v, id=%v, err=%v\n", nameElems[0], nameElems[1], doc.Meta.ID, err)
+		return errorStatus(err)
+	}
+	return fuse.OK
+}
+
+func (fs *ElasticsearchFS) Rmdir(name string, context *fuse.Context) (code fuse.Status) {
+	if fs.debug {
+		log.Printf("Rmdir: name=%v\n", name)
+	}
+
+	nameElems := strings.Split(name, "/")
+	if len(nameElems) >= 2 && nameElems[1] == searchDirName {
+		return fs.removeSearch(nameElems[0], nameElems[2:], true)
+	}
+
+	// The pending index has nothing in Elasticsearch to lose
+	if len(nameElems) == 1 {
+		_, ok := fs.pendingIndices.Take(nameElems[0])
 		if ok {
-			return nodefs.NewDataFile(docSource), fuse.OK
+			return fuse.OK
 		}
 	}
-	return nil, fuse.ENOENT
+
+	// Only the index directories are removable, and the removal deletes the index with its documents
+	if !fs.allowDestructive || len(nameElems) != 1 {
+		return fuse.EPERM
+	}
+	err := fs.cache.DeleteIndex(nameElems[0])
+	if err != nil {
+		log.Printf("Failed to delete the index: index=%v, err=%v\n", nameElems[0], err)
+		return errorStatus(err)
+	}
+	return fuse.OK
+}
+
+// commitDocument returns the function to save the written content of the document file in the format.
+// The content is rejected with EINVAL unless it is an object, so a malformed edit never reaches the index.
+// If the ID is empty, a new ID is generated by the first save and reused by the following ones.
+// The routing of the existing document is kept, so that it is saved into the same shard.
+func (fs *ElasticsearchFS) commitDocument(index string, dtype string, id string, routing string, format string) func([]byte) fuse.Status {
+	return func(data []byte) fuse.Status {
+		data, err := ParseSource(data, format)
+		if err != nil {
+			if fs.debug {
+				log.Printf("Rejected the malformed document: index=%v, dtype=%v, id=%v, err=%v\n", index, dtype, id, err)
+			}
+			return fuse.EINVAL
+		}
+		var source map[string]interface{}
+		err = json.Unmarshal(data, &source)
+		if err != nil || source == nil {
+			if fs.debug {
+				log.Printf("Rejected the malformed document: index=%v, dtype=%v, id=%v, err=%v\n", index, dtype, id, err)
+			}
+			return fuse.EINVAL
+		}
+		savedID, err := fs.cache.SaveDocument(index, dtype, id, routing, append([]byte(nil), data...))
+		if err != nil {
+			log.Printf("Failed to save the doc: index=%v, dtype=%v, id=%v, err=%v\n", index, dtype, id, err)
+			return errorStatus(err)
+		}
+		if fs.debug && id == "" {
+			log.Printf("Created the doc: index=%v, dtype=%v, id=%v\n", index, dtype, savedID)
+		}
+		id = savedID
+		return fuse.OK
+	}
 }
@@ -1,16 +1,88 @@
 package main
 
 import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
 )
 
-func MountFilesystem(fs pathfs.FileSystem, point string) error {
+// The number of times to retry the unmount while the mountpoint is busy
+const unmountRetries = 10
+
+// MountFilesystem serves the filesystem on the mountpoint until it is unmounted or the process is interrupted.
+// The mounted function is called after the filesystem is mounted.
+func MountFilesystem(fs pathfs.FileSystem, config *Config, mounted func()) error {
+	point := config.MountPath
+
+	// Replace the owner of the files with the given one
+	opts := nodefs.NewOptions()
+	if config.UID >= 0 {
+		opts.Owner.Uid = uint32(config.UID)
+	}
+	if config.GID >= 0 {
+		opts.Owner.Gid = uint32(config.GID)
+	}
+
+	// The URL is shown as the source of the mount by df(1), but only the first one because fusermount splits the options by commas
+	mountOpts := fuse.MountOptions{
+		AllowOther: config.AllowOther,
+		FsName:     RedactURL(config.Client.URLs[0]),
+		Name:       "elasticsearch",
+	}
+	if len(config.Clusters) > 0 {
+		mountOpts.FsName = "elasticsearch"
+	}
+	if config.ReadOnly {
+		mountOpts.Options = append(mountOpts.Options, "ro")
+	}
+	if config.DefaultPermissions {
+		mountOpts.Options = append(mountOpts.Options, "default_permissions")
+	}
+
 	nodeFs := pathfs.NewPathNodeFs(fs, nil)
-	server, _, err := nodefs.MountRoot(point, nodeFs.Root(), nil)
+	conn := nodefs.NewFileSystemConnector(nodeFs.Root(), opts)
+	server, err := fuse.NewServer(conn.RawFS(), point, &mountOpts)
 	if err != nil {
 		return err
 	}
+	mounted()
+
+	// Unmount on the signals, so that a stopped process does not leave the stale mountpoint
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			log.Printf("Unmounting by the signal: signal=%v, mountpoint=%v\n", sig, point)
+			unmountServer(server, point)
+		}
+	}()
+
 	server.Serve()
 	return nil
 }
+
+// unmountServer unmounts the filesystem, and retries while it is busy by the open files.
+// If the retries fail, the filesystem is lazily detached from the mountpoint.
+func unmountServer(server *fuse.Server, point string) {
+	var err error
+	for try := 0; try < unmountRetries; try++ {
+		err = server.Unmount()
+		if err == nil {
+			return
+		}
+		log.Printf("Failed to unmount: mountpoint=%v, err=%v\n", point, err)
+		time.Sleep(time.Second)
+	}
+	err = exec.Command("fusermount", "-u", "-z", point).Run()
+	if err != nil {
+		log.Printf("Failed to unmount lazily: mountpoint=%v, err=%v\n", point, err)
+	}
+}
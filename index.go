@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// The files of the index definition in each index directory
+var indexFileNames = []string{mappingFileName, settingsFileName, aliasesFileName}
+
+func isIndexFileName(fileName string) bool {
+	for _, indexFileName := range indexFileNames {
+		if fileName == indexFileName {
+			return true
+		}
+	}
+	return false
+}
+
+// The keys of the body to create an index by the files of the index definition
+var indexFileKeys = map[string]string{
+	mappingFileName:  "mappings",
+	settingsFileName: "settings",
+	aliasesFileName:  "aliases",
+}
+
+// The characters which Elasticsearch rejects in the index names
+const invalidIndexNameChars = "\\/*?\"<>| ,#:"
+
+// isValidIndexName reports whether Elasticsearch accepts the name for a new index.
+// The name must be lowercase, and must not start with '_', '-' or '+'.
+func isValidIndexName(index string) bool {
+	if index == "" || index == "." || index == ".." || len(index) > 255 {
+		return false
+	}
+	if strings.ContainsAny(index[:1], "_-+") || strings.ContainsAny(index, invalidIndexNameChars) {
+		return false
+	}
+	return index == strings.ToLower(index)
+}
+
+// PendingIndexRegistry keeps the indices made by mkdir until they are created in Elasticsearch.
+// The creation is deferred, so that the settings fixed at the creation such as the number of shards can be written into the directory.
+// The pending indices live only in the memory of the mount, so they are lost by unmounting it.
+type PendingIndexRegistry struct {
+	mu      sync.Mutex
+	indices map[string]map[string][]byte // index -> file name -> written content
+}
+
+func (r *PendingIndexRegistry) Add(index string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.indices[index]
+	if ok {
+		return false
+	}
+	if r.indices == nil {
+		r.indices = make(map[string]map[string][]byte)
+	}
+	r.indices[index] = make(map[string][]byte)
+	return true
+}
+
+func (r *PendingIndexRegistry) Has(index string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.indices[index]
+	return ok
+}
+
+func (r *PendingIndexRegistry) List() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	indices := make([]string, 0, len(r.indices))
+	for index := range r.indices {
+		indices = append(indices, index)
+	}
+	return indices
+}
+
+// GetFile returns the written content of the file, and false if the index is not pending.
+func (r *PendingIndexRegistry) GetFile(index string, fileName string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files, ok := r.indices[index]
+	if !ok {
+		return nil, false
+	}
+	return files[fileName], true
+}
+
+// PutFile keeps the written content of the file, and returns false if the index is not pending.
+func (r *PendingIndexRegistry) PutFile(index string, fileName string, data []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files, ok := r.indices[index]
+	if !ok {
+		return false
+	}
+	files[fileName] = append([]byte(nil), data...)
+	return true
+}
+
+// Take removes the pending index, and returns its written files.
+func (r *PendingIndexRegistry) Take(index string) (map[string][]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files, ok := r.indices[index]
+	delete(r.indices, index)
+	return files, ok
+}
+
+// Restore puts back the files of the index taken by Take, unless the index is pending again.
+func (r *PendingIndexRegistry) Restore(index string, files map[string][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.indices[index]
+	if ok {
+		return
+	}
+	if r.indices == nil {
+		r.indices = make(map[string]map[string][]byte)
+	}
+	r.indices[index] = files
+}
+
+// createPendingIndex creates the pending index in Elasticsearch, with the written files of the index definition in the body.
+// It does nothing if the index is not pending.
+func (fs *ElasticsearchFS) createPendingIndex(index string) fuse.Status {
+	files, ok := fs.pendingIndices.Take(index)
+	if !ok {
+		return fuse.OK
+	}
+	var body []byte
+	if len(files) > 0 {
+		definition := make(map[string]json.RawMessage)
+		for fileName, data := range files {
+			definition[indexFileKeys[fileName]] = data
+		}
+		var err error
+		body, err = json.Marshal(definition)
+		if err != nil {
+			fs.pendingIndices.Restore(index, files)
+			return fuse.EIO
+		}
+	}
+	err := fs.cache.CreateIndex(index, body)
+	if err != nil {
+		log.Printf("Failed to create the index: index=%v, err=%v\n", index, err)
+		fs.pendingIndices.Restore(index, files)
+		return errorStatus(err)
+	}
+	return fuse.OK
+}
+
+// commitPendingIndexFile keeps the written file of the pending index.
+// Writing the settings or the mappings creates the index with the files written so far,
+// and the mappings written after the settings are put to the created index, so that they can refer to its analyzers.
+func (fs *ElasticsearchFS) commitPendingIndexFile(index string, fileName string, data []byte) fuse.Status {
+	var definition map[string]interface{}
+	err := json.Unmarshal(data, &definition)
+	if err != nil || definition == nil {
+		return fuse.EINVAL
+	}
+	if !fs.pendingIndices.PutFile(index, fileName, data) {
+		// The index has been created in the meantime
+		return fs.commitIndexFile(index, fileName)(data)
+	}
+	if fileName == settingsFileName || fileName == mappingFileName {
+		return fs.createPendingIndex(index)
+	}
+	return fuse.OK
+}
+
+// The prefix which Elasticsearch adds to the names of the index settings without it
+const indexSettingsPrefix = "index."
+
+// ensureIndexFile returns the mappings, the settings or the aliases of the index as pretty-printed JSON.
+// They have the same layouts as the responses of GET _mapping, GET _settings and GET _alias for the index,
+// so the mappings are keyed by the document types unless the indices have no types.
+// The files of the pending index have the written contents.
+func (fs *ElasticsearchFS) ensureIndexFile(index string, fileName string) ([]byte, fuse.Status) {
+	var raw json.RawMessage
+	pendingData, pending := fs.pendingIndices.GetFile(index, fileName)
+	var metadata IndexMetadata
+	if !pending {
+		var err error
+		metadata, err = fs.cache.EnsureIndexMetadata(index)
+		if err != nil {
+			log.Printf("Failed to ensure the index metadata: index=%v, err=%v\n", index, err)
+			return nil, errorStatus(err)
+		}
+	}
+	switch fileName {
+	case mappingFileName:
+		raw = metadata.Mappings
+	case settingsFileName:
+		raw = metadata.Settings
+	case aliasesFileName:
+		raw = metadata.Aliases
+	default:
+		return nil, fuse.ENOENT
+	}
+	if pending {
+		raw = pendingData
+	}
+	if len(raw) == 0 {
+		raw = json.RawMessage("{}")
+	}
+	data, err := FormatSource(raw, prettyFormat)
+	if err != nil {
+		log.Printf("Failed to format the index metadata: index=%v, file=%v, err=%v\n", index, fileName, err)
+		return nil, fuse.EIO
+	}
+	return data, fuse.OK
+}
+
+func (fs *ElasticsearchFS) openIndexFile(index string, fileName string, flags uint32) (nodefs.File, fuse.Status) {
+	data, code := fs.ensureIndexFile(index, fileName)
+	if !code.Ok() {
+		return nil, code
+	}
+	if flags&fuse.O_ANYWRITE != 0 {
+		return NewBufferFile(data, fs.commitIndexFile(index, fileName)), fuse.OK
+	}
+	return nodefs.NewDataFile(data), fuse.OK
+}
+
+// commitIndexFile returns the function to apply the written content of the file of the index definition.
+func (fs *ElasticsearchFS) commitIndexFile(index string, fileName string) func([]byte) fuse.Status {
+	if fs.pendingIndices.Has(index) {
+		return func(data []byte) fuse.Status {
+			return fs.commitPendingIndexFile(index, fileName, data)
+		}
+	}
+	switch fileName {
+	case mappingFileName:
+		return func(data []byte) fuse.Status {
+			return fs.commitMapping(index, data)
+		}
+	case settingsFileName:
+		return func(data []byte) fuse.Status {
+			return fs.commitSettings(index, data)
+		}
+	case aliasesFileName:
+		return func(data []byte) fuse.Status {
+			return fs.commitAliases(index, data)
+		}
+	}
+	return func(data []byte) fuse.Status {
+		return fuse.EPERM
+	}
+}
+
+// commitMapping puts the mapping of each document type.
+// The existing fields can be written back as they are, since Elasticsearch merges the mappings and only the new fields are added.
+func (fs *ElasticsearchFS) commitMapping(index string, data []byte) fuse.Status {
+	var mappings map[string]json.RawMessage
+	err := json.Unmarshal(data, &mappings)
+	if err != nil {
+		return fuse.EINVAL
+	}
+	// The mapping without document types has the properties at the top level
+	_, ok := mappings["properties"]
+	if ok {
+		mappings = map[string]json.RawMessage{typelessDocType: data}
+	}
+	for dtype, mapping := range mappings {
+		err = fs.cache.PutMapping(index, dtype, mapping)
+		if err != nil {
+			log.Printf("Failed to put the mapping: index=%v, dtype=%v, err=%v\n", index, dtype, err)
+			return errorStatus(err)
+		}
+	}
+	return fuse.OK
+}
+
+// commitSettings puts only the settings which are changed from the current ones, so that the whole settings can be written back.
+// Only the dynamic settings are updatable, and the static ones such as index.number_of_shards and the analyzers are rejected with EINVAL.
+// The settings which are not written are kept, and the ones written as null are reset to their defaults.
+func (fs *ElasticsearchFS) commitSettings(index string, data []byte) fuse.Status {
+	var settings map[string]interface{}
+	err := json.Unmarshal(data, &settings)
+	if err != nil {
+		return fuse.EINVAL
+	}
+	metadata, err := fs.cache.EnsureIndexMetadata(index)
+	if err != nil {
+		log.Printf("Failed to ensure the index metadata: index=%v, err=%v\n", index, err)
+		return errorStatus(err)
+	}
+	var current map[string]interface{}
+	if len(metadata.Settings) > 0 {
+		err = json.Unmarshal(metadata.Settings, &current)
+		if err != nil {
+			return fuse.EIO
+		}
+	}
+	currentSettings := flattenSettings("", current)
+
+	changed := make(map[string]interface{})
+	for key, value := range flattenSettings("", settings) {
+		if !strings.HasPrefix(key, indexSettingsPrefix) {
+			key = indexSettingsPrefix + key
+		}
+		currentValue, ok := currentSettings[key]
+		// The values are compared as strings, because Elasticsearch returns the numbers and the booleans as strings
+		if ok && value != nil && fmt.Sprint(currentValue) == fmt.Sprint(value) {
+			continue
+		}
+		changed[key] = value
+	}
+	if len(changed) == 0 {
+		return fuse.OK
+	}
+	body, err := json.Marshal(changed)
+	if err != nil {
+		return fuse.EIO
+	}
+	err = fs.cache.PutSettings(index, body)
+	if err != nil {
+		log.Printf("Failed to put the settings: index=%v, err=%v\n", index, err)
+		return errorStatus(err)
+	}
+	return fuse.OK
+}
+
+// flattenSettings returns the settings by the dotted names, which Elasticsearch accepts as well as the nested objects.
+func flattenSettings(prefix string, settings map[string]interface{}) map[string]interface{} {
+	flattened := make(map[string]interface{})
+	for key, value := range settings {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			flattened[prefix+key] = value
+			continue
+		}
+		for nestedKey, nestedValue := range flattenSettings(prefix+key+".", nested) {
+			flattened[nestedKey] = nestedValue
+		}
+	}
+	return flattened
+}
+
+// commitAliases adds the written aliases with their filters and routings, and removes the other aliases of the index.
+func (fs *ElasticsearchFS) commitAliases(index string, data []byte) fuse.Status {
+	var aliases map[string]map[string]interface{}
+	err := json.Unmarshal(data, &aliases)
+	if err != nil {
+		return fuse.EINVAL
+	}
+	metadata, err := fs.cache.EnsureIndexMetadata(index)
+	if err != nil {
+		log.Printf("Failed to ensure the index metadata: index=%v, err=%v\n", index, err)
+		return errorStatus(err)
+	}
+	var current map[string]json.RawMessage
+	if len(metadata.Aliases) > 0 {
+		err = json.Unmarshal(metadata.Aliases, &current)
+		if err != nil {
+			return fuse.EIO
+		}
+	}
+
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var actions []interface{}
+	for _, name := range names {
+		action := map[string]interface{}{"index": index, "alias": name}
+		for key, value := range aliases[name] {
+			action[key] = value
+		}
+		actions = append(actions, map[string]interface{}{"add": action})
+	}
+	for name := range current {
+		_, ok := aliases[name]
+		if !ok {
+			actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": index, "alias": name}})
+		}
+	}
+	if len(actions) == 0 {
+		return fuse.OK
+	}
+	body, err := json.Marshal(actions)
+	if err != nil {
+		return fuse.EIO
+	}
+	err = fs.cache.UpdateAliases(index, body)
+	if err != nil {
+		log.Printf("Failed to update the aliases: index=%v, err=%v\n", index, err)
+		return errorStatus(err)
+	}
+	return fuse.OK
+}
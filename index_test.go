@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlattenSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		"number_of_replicas": "1",
+		"refresh_interval":   "1s",
+		"analysis": map[string]interface{}{
+			"analyzer": map[string]interface{}{
+				"default": map[string]interface{}{"type": "keyword"},
+			},
+		},
+		"blocks": map[string]interface{}{"read_only": "false"},
+	}
+	expected := map[string]interface{}{
+		"index.number_of_replicas":             "1",
+		"index.refresh_interval":               "1s",
+		"index.analysis.analyzer.default.type": "keyword",
+		"index.blocks.read_only":               "false",
+	}
+	actual := flattenSettings("index.", settings)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected=%v, actual=%v", expected, actual)
+	}
+
+	// The settings without the prefix keep their own keys at the top
+	actual = flattenSettings("", map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "3"}})
+	if !reflect.DeepEqual(actual, map[string]interface{}{"index.number_of_shards": "3"}) {
+		t.Errorf("actual=%v", actual)
+	}
+}
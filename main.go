@@ -1,9 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/hanwen/go-fuse/fuse/pathfs"
 	"github.com/urfave/cli"
 )
 
@@ -11,12 +15,187 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "elasticsearch-fuse"
 	app.Version = "0.2.0"
-	app.Flags = []cli.Flag{
+	app.ArgsUsage = "[urls] [mountpoint]"
+	app.Flags = appFlags()
+	// mount(8) runs the command as the mount helper by the name like mount.fuse.elasticsearch
+	args := os.Args
+	mount := true
+	if strings.HasPrefix(filepath.Base(args[0]), "mount.") {
+		args, mount = MountHelperArgs(args)
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "config",
+			Usage: "Manage the configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:      "validate",
+					ArgsUsage: "[urls] [mountpoint]",
+					Usage:     "Report the errors of the configuration without mounting the filesystem",
+					Action: func(c *cli.Context) error {
+						// The flags are given to the application, not to the subcommand
+						root := c
+						for root.Parent() != nil {
+							root = root.Parent()
+						}
+						config, err := NewConfig(root, c.Args())
+						if err != nil {
+							return err
+						}
+						err = config.Validate()
+						if err != nil {
+							return err
+						}
+						fmt.Println("The configuration is valid")
+						return nil
+					},
+				},
+			},
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		config, err := NewConfig(c, c.Args())
+		if err != nil {
+			return err
+		}
+		if !mount {
+			return nil
+		}
+
+		// Run the same command as the daemon, which reports back once it mounts the filesystem
+		if !config.Foreground && !IsDaemon() {
+			return Daemonize(args[1:])
+		}
+		if IsDaemon() {
+			SetupDaemon(app.Name)
+		}
+
+		// Load the saved queries to mount as directories
+		queries, err := config.SavedQueries()
+		if err != nil {
+			return err
+		}
+
+		// Create the filesystem is specialized for Elasticsearch, for each of the clusters
+		clusters := make(map[string]pathfs.FileSystem)
+		for name, client := range config.Clients() {
+			if config.Debug {
+				log.Printf("Connecting: cluster=%v, urls=%v, username=%v\n", name, RedactURLs(client.URLs), client.Username)
+			}
+			clusterQueries := make(map[string]SavedQuery)
+			for queryName, query := range queries {
+				if query.Cluster == "" || query.Cluster == name {
+					clusterQueries[queryName] = query
+				}
+			}
+			clusters[name], err = NewElasticsearchFS(client, FSOptions{
+				PageSize:         config.PageSize,
+				UpdateInterval:   config.UpdateInterval,
+				Queries:          clusterQueries,
+				AllowDestructive: config.AllowDestructive,
+				Format:           config.Format,
+				Flatten:          config.Flatten,
+				Debug:            config.Debug,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// A single cluster is mounted directly, and the named clusters are mounted as the directories
+		fs, ok := clusters[""]
+		if !ok {
+			fs = NewClustersFS(clusters)
+		}
+
+		// Start the FUSE server
+		var removePidFile func()
+		err = MountFilesystem(fs, config, func() {
+			if config.PidPath != "" {
+				var pidErr error
+				removePidFile, pidErr = WritePidFile(config.PidPath)
+				if pidErr != nil {
+					log.Printf("Failed to write the pidfile: path=%v, err=%v\n", config.PidPath, pidErr)
+				}
+			}
+			NotifyDaemonParent(nil)
+		})
+		if removePidFile != nil {
+			removePidFile()
+		}
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+	err := app.Run(ReorderArgs(args, app))
+	if err != nil {
+		NotifyDaemonParent(err)
+		log.Fatal(err)
+	}
+}
+
+// appFlags returns the flags of the command, which are given by the command line, -o, the environment variables and the configuration file.
+func appFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "YAML file of the settings by the names of the flags, which are overridden by $" + envPrefix + "<FLAG> and the flags",
+		},
 		cli.StringFlag{
 			Name:  "urls",
 			Value: "http://localhost:9200",
 			Usage: "Elasticsearch server URLs",
 		},
+		cli.StringFlag{
+			Name:   "username",
+			Usage:  "User name for the basic authentication",
+			EnvVar: "ELASTICSEARCH_USERNAME",
+		},
+		cli.StringFlag{
+			Name:  "password-file",
+			Usage: "File of the password for the basic authentication, instead of $" + passwordEnv,
+		},
+		cli.StringFlag{
+			Name:  "api-key-file",
+			Usage: "File of the encoded API key, instead of $" + apiKeyEnv,
+		},
+		cli.StringFlag{
+			Name:  "bearer-token-file",
+			Usage: "File of the bearer token, instead of $" + bearerTokenEnv,
+		},
+		cli.StringFlag{
+			Name:  "ca-cert",
+			Usage: "PEM file of the CA certificates to verify the servers",
+		},
+		cli.StringFlag{
+			Name:  "client-cert",
+			Usage: "PEM file of the client certificate for the mutual TLS",
+		},
+		cli.StringFlag{
+			Name:  "client-key",
+			Usage: "PEM file of the client key for the mutual TLS",
+		},
+		cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "Skip verifying the certificates of the servers",
+		},
+		cli.BoolTFlag{
+			Name:  "sniff",
+			Usage: "Find the nodes of the cluster by sniffing, which falls back to the given URLs if it fails",
+		},
+		cli.BoolTFlag{
+			Name:  "healthcheck",
+			Usage: "Check the health of the nodes periodically",
+		},
+		cli.IntFlag{
+			Name:  "timeout",
+			Usage: "Timeout seconds of each request to Elasticsearch, or 0 for no timeout",
+		},
+		cli.IntFlag{
+			Name:  "retries",
+			Usage: "The number of times to retry the failed requests to Elasticsearch",
+		},
 		cli.StringFlag{
 			Name:  "mount-path",
 			Value: "./elasticsearch-fuse",
@@ -27,34 +206,65 @@ func main() {
 			Value: 10,
 			Usage: "The number of documents to list in one directory",
 		},
-		// TODO: updateInterval := flag.Int("update-interval", 10, "Interval seconds of same queries to Elasticsearch")
+		cli.StringFlag{
+			Name:  "format",
+			Value: jsonFormat,
+			Usage: "Format to show the documents in: json, pretty, yaml or ndjson",
+		},
+		cli.BoolFlag{
+			Name:  "flatten",
+			Usage: "Show the documents as the directories of their fields, in addition to the files by the suffixes of the formats",
+		},
+		cli.StringFlag{
+			Name:  "queries",
+			Usage: "YAML or JSON file of the saved queries to mount under _queries",
+		},
+		cli.BoolFlag{
+			Name:  "allow-destructive",
+			Usage: "Allow to delete documents and indices by removing files and directories",
+		},
+		cli.IntFlag{
+			Name:  "update-interval",
+			Value: 10,
+			Usage: "Interval seconds of same queries to Elasticsearch",
+		},
+		cli.StringSliceFlag{
+			Name:  "o",
+			Usage: "Comma-separated options in the form of mount(8), such as ro,allow_other,page=20,ttl=60",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "Mount the filesystem read-only",
+		},
+		cli.BoolFlag{
+			Name:  "allow-other",
+			Usage: "Allow other users to access the filesystem",
+		},
+		cli.BoolFlag{
+			Name:  "default-permissions",
+			Usage: "Let the kernel check the permissions by the file modes",
+		},
+		cli.IntFlag{
+			Name:  "uid",
+			Value: -1,
+			Usage: "User ID to own the files, instead of the user who mounts",
+		},
+		cli.IntFlag{
+			Name:  "gid",
+			Value: -1,
+			Usage: "Group ID to own the files, instead of the group of the user who mounts",
+		},
+		cli.BoolFlag{
+			Name:  "foreground",
+			Usage: "Stay in the foreground instead of running as a daemon",
+		},
+		cli.StringFlag{
+			Name:  "pidfile",
+			Usage: "File path to write the process ID while the filesystem is mounted",
+		},
 		cli.BoolFlag{
 			Name:  "debug",
 			Usage: "Emit debug logs",
 		},
 	}
-	app.Action = func(c *cli.Context) error {
-		// Get command options
-		urls := c.String("urls")
-		mountPath := c.String("mount")
-		pageSize := c.Int("page")
-		debug := c.Bool("debug")
-
-		// Create the filesystem is specialized for Elasticsearch
-		fs, err := NewElasticsearchFS(urls, pageSize, debug)
-		if err != nil {
-			return err
-		}
-
-		// Start the FUSE server
-		err = MountFilesystem(fs, mountPath)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
-	}
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+const (
+	// The directory in each index to show the metadata of the documents, in the same layout as the document types like _meta/<type>/<page>/<id>.json
+	// It cannot hide any document type, since the names of the types never start with an underscore.
+	metaDirName = "_meta"
+
+	// The suffix of the files in the metadata directory, which are named by the document IDs
+	metaFileSuffix = ".json"
+)
+
+// isMetaPath reports whether the path is in the metadata directory of an index.
+func isMetaPath(nameElems []string) bool {
+	return len(nameElems) >= 2 && nameElems[1] == metaDirName
+}
+
+// The metadata directory mirrors the document types and their pages, so its handlers take the rest of the path as <type>/<page>/<id>.json.
+// The files are the read-only JSON of DocumentMeta, which is kept from the search hits along with the sources.
+
+// ensureMeta returns the metadata of the document in the page as JSON.
+func (fs *ElasticsearchFS) ensureMeta(index string, dtype string, page int, fileName string) ([]byte, fuse.Status) {
+	if !strings.HasSuffix(fileName, metaFileSuffix) {
+		return nil, fuse.ENOENT
+	}
+	docs, err := fs.cache.EnsureDocuments(index, dtype, page)
+	if err != nil {
+		log.Printf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", index, dtype, page, err)
+		return nil, errorStatus(err)
+	}
+	doc, ok := docs[strings.TrimSuffix(fileName, metaFileSuffix)]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	meta, err := json.Marshal(doc.Meta)
+	if err != nil {
+		return nil, fuse.EIO
+	}
+	return meta, fuse.OK
+}
+
+func (fs *ElasticsearchFS) getMetaAttr(index string, nameElems []string) (*fuse.Attr, fuse.Status) {
+	// Return the attribute of the directory, which exists as the index, the document type or the paging directory does
+	if len(nameElems) <= 2 {
+		attr, code := fs.GetAttr(strings.Join(append([]string{index}, nameElems...), "/"), nil)
+		if !code.Ok() {
+			return nil, code
+		}
+		if attr.Mode&fuse.S_IFDIR == 0 {
+			return nil, fuse.ENOENT
+		}
+		attr.Mode = fuse.S_IFDIR | 0555
+		return attr, fuse.OK
+	}
+
+	// Return the attribute of the metadata file
+	if len(nameElems) != 3 {
+		return nil, fuse.ENOENT
+	}
+	page, err := strconv.Atoi(nameElems[1])
+	if err != nil || page < 0 {
+		return nil, fuse.ENOENT
+	}
+	meta, code := fs.ensureMeta(index, nameElems[0], page, nameElems[2])
+	if !code.Ok() {
+		return nil, code
+	}
+	return &fuse.Attr{Mode: fuse.S_IFREG | 0444, Size: uint64(len(meta))}, fuse.OK
+}
+
+func (fs *ElasticsearchFS) openMetaDir(index string, nameElems []string) ([]fuse.DirEntry, fuse.Status) {
+	var entries []fuse.DirEntry
+
+	// If the metadata directory is opened, list up the document types
+	if len(nameElems) == 0 {
+		dtypes, err := fs.cache.EnsureDocumentTypes(index)
+		if err != nil {
+			log.Printf("Failed to ensure the document types: index=%v, err=%v\n", index, err)
+			return nil, errorStatus(err)
+		}
+		for _, dtype := range dtypes {
+			entries = append(entries, fuse.DirEntry{Name: dtype, Mode: fuse.S_IFDIR})
+		}
+		return entries, fuse.OK
+	}
+
+	// If the document type is opened, list up the pages
+	if len(nameElems) == 1 {
+		total, err := fs.cache.EnsureDocumentTotal(index, nameElems[0])
+		if err != nil {
+			log.Printf("Failed to ensure the docs: index=%v, dtype=%v, err=%v\n", index, nameElems[0], err)
+			return nil, errorStatus(err)
+		}
+		for i := 0; int64(i*fs.cache.pageSize) < total; i++ {
+			entries = append(entries, fuse.DirEntry{Name: strconv.Itoa(i), Mode: fuse.S_IFDIR})
+		}
+		return entries, fuse.OK
+	}
+
+	// If the paging directory is opened, list up the metadata files of the documents
+	if len(nameElems) != 2 {
+		return nil, fuse.ENOENT
+	}
+	page, err := strconv.Atoi(nameElems[1])
+	if err != nil || page < 0 {
+		return nil, fuse.ENOENT
+	}
+	docs, err := fs.cache.EnsureDocuments(index, nameElems[0], page)
+	if err != nil {
+		log.Printf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", index, nameElems[0], page, err)
+		return nil, errorStatus(err)
+	}
+	for docID := range docs {
+		entries = append(entries, fuse.DirEntry{Name: docID + metaFileSuffix, Mode: fuse.S_IFREG})
+	}
+	return entries, fuse.OK
+}
+
+func (fs *ElasticsearchFS) openMetaFile(index string, nameElems []string, flags uint32) (nodefs.File, fuse.Status) {
+	if len(nameElems) != 3 {
+		return nil, fuse.ENOENT
+	}
+	page, err := strconv.Atoi(nameElems[1])
+	if err != nil || page < 0 {
+		return nil, fuse.ENOENT
+	}
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EPERM
+	}
+	meta, code := fs.ensureMeta(index, nameElems[0], page, nameElems[2])
+	if !code.Ok() {
+		return nil, code
+	}
+	return nodefs.NewDataFile(meta), fuse.OK
+}
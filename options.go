@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// ReorderArgs moves the flags in front of the positional arguments.
+// mount(8) runs the helpers like `elasticsearch-fuse <urls> <mountpoint> -o <options>`,
+// but the flags after the first positional argument are not parsed by the command line parser.
+// The arguments are not reordered if they run a subcommand.
+func ReorderArgs(args []string, app *cli.App) []string {
+	if len(args) == 0 {
+		return args
+	}
+
+	// The flags without values do not take the next argument
+	boolFlags := map[string]bool{"help": true, "h": true, "version": true, "v": true}
+	for _, flag := range app.Flags {
+		switch flag.(type) {
+		case cli.BoolFlag, cli.BoolTFlag:
+		default:
+			continue
+		}
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			boolFlags[strings.TrimSpace(name)] = true
+		}
+	}
+
+	var flagArgs, positionalArgs []string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positionalArgs = append(positionalArgs, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			if len(positionalArgs) == 0 && app.Command(arg) != nil {
+				return args
+			}
+			positionalArgs = append(positionalArgs, arg)
+			continue
+		}
+		flagArgs = append(flagArgs, arg)
+		name := strings.TrimLeft(arg, "-")
+		if !strings.Contains(name, "=") && !boolFlags[name] && i+1 < len(args) {
+			i++
+			flagArgs = append(flagArgs, args[i])
+		}
+	}
+
+	reordered := append([]string{args[0]}, flagArgs...)
+	if len(positionalArgs) > 0 {
+		reordered = append(reordered, "--")
+		reordered = append(reordered, positionalArgs...)
+	}
+	return reordered
+}
+
+// The flags to set by the mount options whose names are different from them
+var mountOptionFlags = map[string]string{
+	"ro":                  "read-only",
+	"allow_other":         "allow-other",
+	"default_permissions": "default-permissions",
+	"ttl":                 "update-interval",
+}
+
+// MountHelperArgs converts the arguments given by mount(8) to the mount helper into the ones of the command.
+// mount(8) runs the helper like `mount.fuse.elasticsearch <urls> <mountpoint> [-sfnv] [-N namespace] [-o options] [-t type]`.
+// It returns false if the filesystem should not be mounted actually, which is requested by -f.
+func MountHelperArgs(args []string) ([]string, bool) {
+	converted := []string{args[0]}
+	mount := true
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-s", "-n", "-v":
+			// Ignore sloppy options, no mtab and verbose
+		case "-f":
+			mount = false
+		case "-t", "-N":
+			// Ignore the filesystem type and the namespace, which have been resolved by mount(8)
+			i++
+		default:
+			converted = append(converted, args[i])
+		}
+	}
+	return converted, mount
+}
+
+// ParseMountOptions parses the comma-separated options of `-o`, and returns the values by their keys.
+// The options without values are returned with "true".
+// Since the URLs are also comma-separated, the URLs following `urls=` are joined to its value like `urls=http://a:9200,http://b:9200`.
+func ParseMountOptions(options []string) map[string]string {
+	values := make(map[string]string)
+	for _, option := range options {
+		var lastKey string
+		for _, elem := range strings.Split(option, ",") {
+			if elem == "" {
+				continue
+			}
+			kv := strings.SplitN(elem, "=", 2)
+			if len(kv) == 1 {
+				if lastKey == "urls" && strings.Contains(elem, "://") {
+					values[lastKey] += "," + elem
+					continue
+				}
+				values[kv[0]] = "true"
+			} else {
+				values[kv[0]] = kv[1]
+			}
+			lastKey = kv[0]
+		}
+	}
+	return values
+}
+
+// ApplyMountOptions sets the options of `-o` to the flags with the same names, unless the flags are given explicitly.
+func ApplyMountOptions(c *cli.Context, options map[string]string) error {
+	names := flagNames(c.App.Flags)
+	for option, value := range options {
+		key := option
+		name, ok := mountOptionFlags[key]
+		if ok {
+			key = name
+		}
+		if !names[key] {
+			// mount(8) passes the generic options such as rw and _netdev, which have nothing to do here
+			log.Printf("Ignored the mount option: option=%v\n", option)
+			continue
+		}
+		if c.IsSet(key) {
+			continue
+		}
+		err := c.Set(key, value)
+		if err != nil {
+			return fmt.Errorf("invalid mount option: option=%v, value=%v, err=%v", option, value, err)
+		}
+	}
+	return nil
+}
+
+// flagNames returns the set of the names of the flags, including their aliases.
+func flagNames(flags []cli.Flag) map[string]bool {
+	names := make(map[string]bool)
+	for _, flag := range flags {
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			names[strings.TrimSpace(name)] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestReorderArgs(t *testing.T) {
+	app := cli.NewApp()
+	app.Flags = appFlags()
+	app.Commands = []cli.Command{{Name: "config"}}
+	for _, c := range []struct {
+		args     []string
+		expected []string
+	}{
+		// The flags after the positional arguments are moved in front of them, with the values of the flags
+		{
+			[]string{"cmd", "http://localhost:9200", "/mnt", "-o", "ro,page=20", "--foreground"},
+			[]string{"cmd", "-o", "ro,page=20", "--foreground", "--", "http://localhost:9200", "/mnt"},
+		},
+		// The boolean flags take no values, and the flags with = keep their values in themselves
+		{
+			[]string{"cmd", "--debug", "http://localhost:9200", "--page=20", "/mnt"},
+			[]string{"cmd", "--debug", "--page=20", "--", "http://localhost:9200", "/mnt"},
+		},
+		// The arguments after -- are positional even if they look like the flags
+		{
+			[]string{"cmd", "--page", "20", "--", "-mnt"},
+			[]string{"cmd", "--page", "20", "--", "-mnt"},
+		},
+		// The subcommands are run as they are
+		{
+			[]string{"cmd", "config", "validate", "--page", "20"},
+			[]string{"cmd", "config", "validate", "--page", "20"},
+		},
+		{
+			[]string{"cmd", "--page", "20"},
+			[]string{"cmd", "--page", "20"},
+		},
+	} {
+		actual := ReorderArgs(c.args, app)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("args=%v, expected=%v, actual=%v", c.args, c.expected, actual)
+		}
+	}
+}
+
+func TestMountHelperArgs(t *testing.T) {
+	for _, c := range []struct {
+		args     []string
+		expected []string
+		mount    bool
+	}{
+		{
+			[]string{"mount.fuse.elasticsearch", "http://localhost:9200", "/mnt", "-o", "rw,page=20", "-t", "fuse.elasticsearch"},
+			[]string{"mount.fuse.elasticsearch", "http://localhost:9200", "/mnt", "-o", "rw,page=20"},
+			true,
+		},
+		{
+			[]string{"mount.fuse.elasticsearch", "http://localhost:9200", "/mnt", "-s", "-n", "-v", "-N", "/proc/1/ns/mnt", "-o", "ro"},
+			[]string{"mount.fuse.elasticsearch", "http://localhost:9200", "/mnt", "-o", "ro"},
+			true,
+		},
+		// mount -f asks to do everything but mounting
+		{
+			[]string{"mount.fuse.elasticsearch", "http://localhost:9200", "/mnt", "-f"},
+			[]string{"mount.fuse.elasticsearch", "http://localhost:9200", "/mnt"},
+			false,
+		},
+	} {
+		actual, mount := MountHelperArgs(c.args)
+		if !reflect.DeepEqual(actual, c.expected) || mount != c.mount {
+			t.Errorf("args=%v, expected=%v, actual=%v, mount=%v", c.args, c.expected, actual, mount)
+		}
+	}
+}
+
+func TestParseMountOptions(t *testing.T) {
+	for _, c := range []struct {
+		options  []string
+		expected map[string]string
+	}{
+		{
+			[]string{"ro,allow_other,page=20", "ttl=60"},
+			map[string]string{"ro": "true", "allow_other": "true", "page": "20", "ttl": "60"},
+		},
+		// The URLs are joined to urls= until another option
+		{
+			[]string{"urls=http://a:9200,http://b:9200,ro"},
+			map[string]string{"urls": "http://a:9200,http://b:9200", "ro": "true"},
+		},
+		{
+			[]string{"format=yaml,,debug"},
+			map[string]string{"format": "yaml", "debug": "true"},
+		},
+	} {
+		actual := ParseMountOptions(c.options)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("options=%v, expected=%v, actual=%v", c.options, c.expected, actual)
+		}
+	}
+}
+
+func TestApplyMountOptionsError(t *testing.T) {
+	_, err := parseConfig("-o", "page=abc", "http://localhost:9200", "/mnt")
+	if err == nil || !strings.Contains(err.Error(), "option=page") {
+		t.Errorf("the error does not name the mount option: err=%v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The top-level directory to mount the saved queries
+const queriesDirName = "_queries"
+
+// SavedQuery is the recurring search which is mounted as a directory of the matching documents.
+type SavedQuery struct {
+	Cluster string // The name of the cluster to search, or empty for all the clusters
+	Index   string
+	Query   []byte
+	Sort    []byte
+}
+
+// LoadSavedQueries reads the saved queries from the YAML or JSON file, which maps the names to the queries like:
+//
+//	failed-payments:
+//	  cluster: prod
+//	  index: payments
+//	  query: {term: {status: failed}}
+//	  sort: [{"@timestamp": desc}]
+func LoadSavedQueries(path string) (map[string]SavedQuery, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs map[string]struct {
+		Cluster string      `yaml:"cluster"`
+		Index   string      `yaml:"index"`
+		Query   interface{} `yaml:"query"`
+		Sort    interface{} `yaml:"sort"`
+	}
+	err = yaml.Unmarshal(data, &configs)
+	if err != nil {
+		return nil, err
+	}
+	queries := make(map[string]SavedQuery)
+	for name, config := range configs {
+		if config.Index == "" {
+			return nil, fmt.Errorf("the saved query has no index: name=%v", name)
+		}
+		query := SavedQuery{Cluster: config.Cluster, Index: config.Index}
+		if config.Query != nil {
+			query.Query, err = json.Marshal(jsonCompatible(config.Query))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if config.Sort != nil {
+			// A single sort field is accepted as well as the array of them
+			sort, ok := jsonCompatible(config.Sort).([]interface{})
+			if !ok {
+				sort = []interface{}{jsonCompatible(config.Sort)}
+			}
+			query.Sort, err = json.Marshal(sort)
+			if err != nil {
+				return nil, err
+			}
+		}
+		queries[name] = query
+	}
+	return queries, nil
+}
+
+// jsonCompatible converts the maps decoded from YAML, whose keys are not always strings, into the ones encodable to JSON.
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(elem)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, elem := range v {
+			a[i] = jsonCompatible(elem)
+		}
+		return a
+	}
+	return value
+}
+
+// The saved queries are fixed by the --queries file, so their directory is read-only,
+// and each of them shows its results in the same layout as a search like _queries/<name>/<page>/<id>.
+
+func (fs *ElasticsearchFS) getQueryAttr(nameElems []string) (*fuse.Attr, fuse.Status) {
+	// Return the attribute of the directory of the saved queries
+	if len(nameElems) == 0 {
+		return &fuse.Attr{Mode: fuse.S_IFDIR | 0555}, fuse.OK
+	}
+
+	query, ok := fs.queries[nameElems[0]]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	// Return the attribute of the directory of the saved query
+	if len(nameElems) == 1 {
+		return &fuse.Attr{Mode: fuse.S_IFDIR | 0555}, fuse.OK
+	}
+
+	// Return the attribute of the paging directory or the matching document
+	return fs.getResultAttr(query.Index, query.Query, query.Sort, nameElems[1:])
+}
+
+func (fs *ElasticsearchFS) openQueryDir(nameElems []string) ([]fuse.DirEntry, fuse.Status) {
+	var entries []fuse.DirEntry
+
+	// If the directory of the saved queries is opened, list up their names
+	if len(nameElems) == 0 {
+		for name := range fs.queries {
+			entries = append(entries, fuse.DirEntry{Name: name, Mode: fuse.S_IFDIR})
+		}
+		return entries, fuse.OK
+	}
+
+	// If the directory of a saved query is opened, list up the pages of the matching documents
+	query, ok := fs.queries[nameElems[0]]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return fs.openResultDir(query.Index, query.Query, query.Sort, nameElems[1:])
+}
+
+func (fs *ElasticsearchFS) openQueryFile(nameElems []string, flags uint32) (nodefs.File, fuse.Status) {
+	if len(nameElems) == 0 {
+		return nil, fuse.ENOENT
+	}
+	query, ok := fs.queries[nameElems[0]]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return fs.openResultFile(query.Index, query.Query, query.Sort, nameElems[1:], flags)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+const (
+	// The directory under each index to mount the searches as directories
+	searchDirName = "_search"
+
+	// The suffix of the files in the search directory, which define searches by query DSL
+	searchFileSuffix = ".json"
+)
+
+// SearchRegistry keeps the searches made in the search directories of the indices.
+// The searches live in memory only while the filesystem is mounted.
+type SearchRegistry struct {
+	mu       sync.Mutex
+	searches map[string]map[string]search
+}
+
+type search struct {
+	query    []byte
+	fromFile bool
+}
+
+func (r *SearchRegistry) Get(index string, name string) (search, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.searches[index][name]
+	return s, ok
+}
+
+func (r *SearchRegistry) List(index string) map[string]search {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	searches := make(map[string]search, len(r.searches[index]))
+	for name, s := range r.searches[index] {
+		searches[name] = s
+	}
+	return searches
+}
+
+func (r *SearchRegistry) Put(index string, name string, s search) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.searches == nil {
+		r.searches = make(map[string]map[string]search)
+	}
+	_, ok := r.searches[index]
+	if !ok {
+		r.searches[index] = make(map[string]search)
+	}
+	r.searches[index][name] = s
+}
+
+func (r *SearchRegistry) Delete(index string, name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.searches[index][name]
+	delete(r.searches[index], name)
+	return ok
+}
+
+// queryStringQuery returns the query DSL of the query string, which is the syntax of the directory names made by mkdir.
+func queryStringQuery(queryString string) []byte {
+	query, _ := json.Marshal(map[string]interface{}{
+		"query_string": map[string]interface{}{"query": queryString},
+	})
+	return query
+}
+
+// A search is made by mkdir with its query string as the name, or by writing its query DSL into <name>.json,
+// and it lives only in the SearchRegistry of the mount until it is removed.
+
+func (fs *ElasticsearchFS) getSearchAttr(index string, nameElems []string) (*fuse.Attr, fuse.Status) {
+	// Return the attribute of the search directory itself
+	if len(nameElems) == 0 {
+		indexs, err := fs.cache.EnsureIndexNames()
+		if err != nil {
+			log.Printf("Failed to ensure the index names: err=%v\n", err)
+			return nil, errorStatus(err)
+		}
+		for _, name := range indexs {
+			if index == name {
+				return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
+			}
+		}
+		return nil, fuse.ENOENT
+	}
+
+	// Return the attribute of the query DSL file
+	if len(nameElems) == 1 && strings.HasSuffix(nameElems[0], searchFileSuffix) {
+		s, ok := fs.searches.Get(index, strings.TrimSuffix(nameElems[0], searchFileSuffix))
+		if ok && s.fromFile {
+			return &fuse.Attr{Mode: fuse.S_IFREG | 0644, Size: uint64(len(s.query))}, fuse.OK
+		}
+	}
+
+	s, ok := fs.searches.Get(index, nameElems[0])
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	// Return the attribute of the directory of the search results
+	if len(nameElems) == 1 {
+		return &fuse.Attr{Mode: fuse.S_IFDIR | 0755}, fuse.OK
+	}
+
+	// Return the attribute of the paging directory or the matching document
+	return fs.getResultAttr(index, s.query, nil, nameElems[1:])
+}
+
+func (fs *ElasticsearchFS) openSearchDir(index string, nameElems []string) ([]fuse.DirEntry, fuse.Status) {
+	var entries []fuse.DirEntry
+
+	// If the search directory is opened, list up the searches and their query DSL files
+	if len(nameElems) == 0 {
+		for name, s := range fs.searches.List(index) {
+			entries = append(entries, fuse.DirEntry{Name: name, Mode: fuse.S_IFDIR})
+			if s.fromFile {
+				entries = append(entries, fuse.DirEntry{Name: name + searchFileSuffix, Mode: fuse.S_IFREG})
+			}
+		}
+		return entries, fuse.OK
+	}
+
+	// If the directory of a search is opened, list up the pages of the matching documents
+	s, ok := fs.searches.Get(index, nameElems[0])
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return fs.openResultDir(index, s.query, nil, nameElems[1:])
+}
+
+func (fs *ElasticsearchFS) openSearchFile(index string, nameElems []string, flags uint32) (nodefs.File, fuse.Status) {
+	// Open the query DSL file to read or to replace the query
+	if len(nameElems) == 1 && strings.HasSuffix(nameElems[0], searchFileSuffix) {
+		name := strings.TrimSuffix(nameElems[0], searchFileSuffix)
+		s, ok := fs.searches.Get(index, name)
+		if !ok || !s.fromFile {
+			return nil, fuse.ENOENT
+		}
+		if flags&fuse.O_ANYWRITE != 0 {
+			return NewBufferFile(s.query, fs.commitSearch(index, name)), fuse.OK
+		}
+		return nodefs.NewDataFile(s.query), fuse.OK
+	}
+
+	// Open the matching document
+	if len(nameElems) == 0 {
+		return nil, fuse.ENOENT
+	}
+	s, ok := fs.searches.Get(index, nameElems[0])
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return fs.openResultFile(index, s.query, nil, nameElems[1:], flags)
+}
+
+func (fs *ElasticsearchFS) createSearchFile(index string, nameElems []string) (nodefs.File, fuse.Status) {
+	if len(nameElems) != 1 || !strings.HasSuffix(nameElems[0], searchFileSuffix) {
+		return nil, fuse.EPERM
+	}
+	file := NewBufferFile(nil, fs.commitSearch(index, strings.TrimSuffix(nameElems[0], searchFileSuffix)))
+	return file, fuse.OK
+}
+
+// commitSearch returns the function to save the written query DSL as the search with the name.
+func (fs *ElasticsearchFS) commitSearch(index string, name string) func([]byte) fuse.Status {
+	return func(data []byte) fuse.Status {
+		var query map[string]interface{}
+		err := json.Unmarshal(data, &query)
+		if err != nil || query == nil {
+			return fuse.EINVAL
+		}
+		fs.searches.Put(index, name, search{query: append([]byte(nil), data...), fromFile: true})
+		return fuse.OK
+	}
+}
+
+func (fs *ElasticsearchFS) mkdirSearch(index string, nameElems []string) fuse.Status {
+	if len(nameElems) != 1 {
+		return fuse.EPERM
+	}
+	_, ok := fs.searches.Get(index, nameElems[0])
+	if ok {
+		return fuse.Status(syscall.EEXIST)
+	}
+	fs.searches.Put(index, nameElems[0], search{query: queryStringQuery(nameElems[0])})
+	return fuse.OK
+}
+
+// removeSearch forgets the search by its directory or its query DSL file.
+// It never touches the documents, so it is allowed without --allow-destructive.
+func (fs *ElasticsearchFS) removeSearch(index string, nameElems []string, isDir bool) fuse.Status {
+	if len(nameElems) != 1 {
+		return fuse.EPERM
+	}
+	name := nameElems[0]
+	if !isDir {
+		if !strings.HasSuffix(name, searchFileSuffix) {
+			return fuse.ENOENT
+		}
+		name = strings.TrimSuffix(name, searchFileSuffix)
+	}
+	if !fs.searches.Delete(index, name) {
+		return fuse.ENOENT
+	}
+	return fuse.OK
+}
+
+// The results of the searches and the saved queries are paged by from and size like <page>/<id>,
+// so the pages beyond maxResultWindow are not shown, and user.es.total_hits tells how many documents match.
+
+// resultPages returns the number of the pages of the matching documents.
+func (fs *ElasticsearchFS) resultPages(index string, query []byte) (int, fuse.Status) {
+	total, err := fs.cache.EnsureSearchTotal(index, query)
+	if err != nil {
+		log.Printf("Failed to ensure the search total: index=%v, query=%s, err=%v\n", index, query, err)
+		return 0, errorStatus(err)
+	}
+	if total > maxResultWindow {
+		total = maxResultWindow
+	}
+	pageSize := int64(fs.cache.pageSize)
+	return int((total + pageSize - 1) / pageSize), fuse.OK
+}
+
+// ensureResultDocuments returns the matching documents in the page by its name.
+func (fs *ElasticsearchFS) ensureResultDocuments(index string, query []byte, sort []byte, pageName string) (map[string]Document, fuse.Status) {
+	page, err := strconv.Atoi(pageName)
+	if err != nil || page < 0 {
+		return nil, fuse.ENOENT
+	}
+	docs, err := fs.cache.EnsureSearchDocuments(index, query, sort, page)
+	if err != nil {
+		log.Printf("Failed to ensure the search docs: index=%v, query=%s, page=%v, err=%v\n", index, query, page, err)
+		return nil, errorStatus(err)
+	}
+	return docs, fuse.OK
+}
+
+func (fs *ElasticsearchFS) getResultAttr(index string, query []byte, sort []byte, nameElems []string) (*fuse.Attr, fuse.Status) {
+	// Return the attribute of the paging directory
+	if len(nameElems) == 1 {
+		page, err := strconv.Atoi(nameElems[0])
+		if err != nil || page < 0 {
+			return nil, fuse.ENOENT
+		}
+		pages, code := fs.resultPages(index, query)
+		if !code.Ok() {
+			return nil, code
+		}
+		if 0 <= page && page < pages {
+			return &fuse.Attr{Mode: fuse.S_IFDIR | 0555}, fuse.OK
+		}
+		return nil, fuse.ENOENT
+	}
+
+	// Return the attribute of the matching document
+	if len(nameElems) == 2 {
+		docs, code := fs.ensureResultDocuments(index, query, sort, nameElems[0])
+		if !code.Ok() {
+			return nil, code
+		}
+		_, data, _, st := fs.readDocument(docs, nameElems[1])
+		if st.Ok() {
+			return &fuse.Attr{Mode: fuse.S_IFREG | 0444, Size: uint64(len(data))}, fuse.OK
+		}
+	}
+	return nil, fuse.ENOENT
+}
+
+func (fs *ElasticsearchFS) openResultDir(index string, query []byte, sort []byte, nameElems []string) ([]fuse.DirEntry, fuse.Status) {
+	var entries []fuse.DirEntry
+
+	// If the directory of the search results is opened, list up the paging directories
+	if len(nameElems) == 0 {
+		pages, code := fs.resultPages(index, query)
+		if !code.Ok() {
+			return nil, code
+		}
+		for i := 0; i < pages; i++ {
+			entries = append(entries, fuse.DirEntry{Name: strconv.Itoa(i), Mode: fuse.S_IFDIR})
+		}
+		return entries, fuse.OK
+	}
+
+	// If the paging directory is opened, list up the matching documents
+	if len(nameElems) != 1 {
+		return nil, fuse.ENOENT
+	}
+	docs, code := fs.ensureResultDocuments(index, query, sort, nameElems[0])
+	if !code.Ok() {
+		return nil, code
+	}
+	for docID := range docs {
+		entries = append(entries, fuse.DirEntry{Name: docID, Mode: fuse.S_IFREG})
+	}
+	return entries, fuse.OK
+}
+
+// openResultFile opens the matching document read-only, since the results are a view of the search
+// and a saved document may leave them; the documents are edited in the directories of their types.
+func (fs *ElasticsearchFS) openResultFile(index string, query []byte, sort []byte, nameElems []string, flags uint32) (nodefs.File, fuse.Status) {
+	if len(nameElems) != 2 {
+		return nil, fuse.ENOENT
+	}
+	if flags&fuse.O_ANYWRITE != 0 {
+		return nil, fuse.EPERM
+	}
+	docs, code := fs.ensureResultDocuments(index, query, sort, nameElems[0])
+	if !code.Ok() {
+		return nil, code
+	}
+	_, data, _, st := fs.readDocument(docs, nameElems[1])
+	if !st.Ok() {
+		return nil, st
+	}
+	return nodefs.NewDataFile(data), fuse.OK
+}
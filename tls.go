@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// NewTLSConfig returns the TLS configuration to verify the cluster by the CA and to present the client certificate.
+// It returns nil if the default configuration is enough.
+func NewTLSConfig(config ClientConfig) (*tls.Config, error) {
+	if config.CACert == "" && config.ClientCert == "" && config.ClientKey == "" && !config.InsecureSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+	// Trust the certificates of the CA instead of the ones of the system
+	if config.CACert != "" {
+		pem, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate is found in the CA file: path=%v", config.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// Present the client certificate for the mutual TLS
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, errors.New("both of the client certificate and the client key must be given")
+	}
+	if config.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
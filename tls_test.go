@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is the certificate and its key, which are also written into PEM files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certPath string
+	keyPath  string
+}
+
+// newTestCert issues the certificate by the parent, or a self-signed one if the parent is nil.
+// The certificates which are not CAs are valid for 127.0.0.1, as both of the servers and the clients.
+func newTestCert(t *testing.T, dir string, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCert{cert: cert, key: key, certPath: filepath.Join(dir, name+".crt"), keyPath: filepath.Join(dir, name+".key")}
+	writePEM(t, c.certPath, "CERTIFICATE", der)
+	writePEM(t, c.keyPath, "EC PRIVATE KEY", keyDer)
+	return c
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsStandIn is the stand-in of Elasticsearch, which answers its version only.
+var tlsStandIn = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"version": {"number": "5.6.0"}}`))
+})
+
+// getVersion connects to the server by the client configuration, and requests its version.
+func getVersion(config ClientConfig) error {
+	client, err := NewElasticsearchClient(config)
+	if err != nil {
+		return err
+	}
+	_, err = client.GetVersion()
+	return err
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "elasticsearch-fuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestNewTLSConfigDefault(t *testing.T) {
+	tlsConfig, err := NewTLSConfig(ClientConfig{URLs: []string{"https://127.0.0.1:9200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		t.Errorf("the TLS configuration is built without any settings: config=%v", tlsConfig)
+	}
+}
+
+func TestNewTLSConfigIncompleteClientCert(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	client := newTestCert(t, dir, "client", nil, false)
+
+	_, err := NewTLSConfig(ClientConfig{ClientCert: client.certPath})
+	if err == nil {
+		t.Error("the client certificate is accepted without the key")
+	}
+	_, err = NewTLSConfig(ClientConfig{ClientKey: client.keyPath})
+	if err == nil {
+		t.Error("the client key is accepted without the certificate")
+	}
+}
+
+func TestElasticsearchClientCustomCA(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	server := httptest.NewTLSServer(tlsStandIn)
+	defer server.Close()
+	caPath := filepath.Join(dir, "ca.crt")
+	writePEM(t, caPath, "CERTIFICATE", server.Certificate().Raw)
+
+	err := getVersion(ClientConfig{URLs: []string{server.URL}, CACert: caPath})
+	if err != nil {
+		t.Errorf("the server is not trusted by the custom CA: err=%v", err)
+	}
+}
+
+func TestElasticsearchClientUntrustedCA(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	server := httptest.NewTLSServer(tlsStandIn)
+	defer server.Close()
+	ca := newTestCert(t, dir, "ca", nil, true)
+
+	err := getVersion(ClientConfig{URLs: []string{server.URL}, CACert: ca.certPath})
+	if err == nil {
+		t.Error("the server is trusted by the CA which has not issued its certificate")
+	}
+}
+
+func TestElasticsearchClientInsecureSkipVerify(t *testing.T) {
+	server := httptest.NewTLSServer(tlsStandIn)
+	defer server.Close()
+
+	err := getVersion(ClientConfig{URLs: []string{server.URL}, InsecureSkipVerify: true})
+	if err != nil {
+		t.Errorf("the server is verified in spite of --insecure-skip-verify: err=%v", err)
+	}
+}
+
+func TestElasticsearchClientMutualTLS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, dir, "ca", nil, true)
+	serverCert := newTestCert(t, dir, "server", ca, false)
+	clientCert := newTestCert(t, dir, "client", ca, false)
+
+	// The server presents the certificate by the CA, and requires the clients to present theirs by the same CA
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	server := httptest.NewUnstartedServer(tlsStandIn)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	err := getVersion(ClientConfig{URLs: []string{server.URL}, CACert: ca.certPath, ClientCert: clientCert.certPath, ClientKey: clientCert.keyPath})
+	if err != nil {
+		t.Errorf("the client certificate is not accepted: err=%v", err)
+	}
+	err = getVersion(ClientConfig{URLs: []string{server.URL}, CACert: ca.certPath})
+	if err == nil {
+		t.Error("the server accepts the client without the certificate")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+// The pseudo document type to keep the layout of the paths for the indices without document types
+const typelessDocType = "_doc"
+
+// TypelessClient is the backend for Elasticsearch 7 and later and OpenSearch, whose indices have no document types.
+// The documents of an index are shown under the pseudo document type.
+// The client for Elasticsearch 5 still works for the APIs which have not changed, such as indexing by /<index>/_doc/<id>.
+type TypelessClient struct {
+	*ElasticsearchClient
+
+	// Whether the documents are paged in a point in time, or by from and size and by scrolling without it
+	pointInTime bool
+}
+
+func NewTypelessClient(client *ElasticsearchClient, pointInTime bool) *TypelessClient {
+	var c TypelessClient
+	c.ElasticsearchClient = client
+	c.pointInTime = pointInTime
+	return &c
+}
+
+// The sort to page the documents in the order of the index without a point in time, which may change between the requests.
+// The documents have no fields unique to search after them, since _uid is removed with the document types
+// and sorting by _id is disabled by default on Elasticsearch 8.
+var typelessPagingSort = []interface{}{"_doc"}
+
+// The sort to page the documents in a point in time, whose values are unique in it to search after them
+var pointInTimePagingSort = []interface{}{map[string]interface{}{"_shard_doc": "asc"}}
+
+// The time to keep the scroll context between the batches
+const typelessScrollKeepAlive = "1m"
+
+func (c *TypelessClient) GetDocumentTypes(index string) ([]string, error) {
+	return []string{typelessDocType}, nil
+}
+
+func (c *TypelessClient) CountDocuments(index string, dtype string) (int64, error) {
+	return c.raw.Count(index).Do(context.Background())
+}
+
+func (c *TypelessClient) GetDocuments(index string, dtype string, query []byte, sort []byte, from int, size int) (map[string]Document, error) {
+	body := map[string]interface{}{"from": from, "size": size, "version": true, "seq_no_primary_term": true}
+	if query != nil {
+		body["query"] = json.RawMessage(query)
+	}
+	if sort != nil {
+		body["sort"] = json.RawMessage(sort)
+	}
+	docs, _, err := c.search(index, body, true)
+	return docs, err
+}
+
+// GetPagedDocuments returns a page within maxResultWindow by from and size, and the pages beyond it are returned by ScrollDocuments.
+// It is used only if the cluster cannot page in a point in time.
+func (c *TypelessClient) GetPagedDocuments(index string, dtype string, from int, searchAfter []interface{}, size int, fetchSource bool) (map[string]Document, []interface{}, error) {
+	if searchAfter != nil {
+		return nil, nil, errors.New("the documents without types cannot be searched after")
+	}
+	body := map[string]interface{}{"from": from, "size": size, "sort": typelessPagingSort, "_source": fetchSource, "version": fetchSource, "seq_no_primary_term": fetchSource}
+	return c.search(index, body, fetchSource)
+}
+
+// OpenPointInTime opens a point in time of the index to page its documents in, if the cluster sorts them by _shard_doc.
+func (c *TypelessClient) OpenPointInTime(index string, keepAlive time.Duration) (string, error) {
+	if !c.pointInTime {
+		return "", nil
+	}
+	params := url.Values{"keep_alive": []string{formatKeepAlive(keepAlive)}}
+	res, err := c.raw.PerformRequest(context.Background(), "POST", "/"+url.PathEscape(index)+"/_pit", params, nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	err = json.Unmarshal(res.Body, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// GetPointInTimeDocuments returns a page of the documents in the point in time, which is kept alive for the following pages.
+// The page starts after the sort values if they are given, otherwise at the offset which must be within maxResultWindow.
+func (c *TypelessClient) GetPointInTimeDocuments(pit string, keepAlive time.Duration, from int, searchAfter []interface{}, size int, fetchSource bool) (map[string]Document, []interface{}, string, error) {
+	body := map[string]interface{}{
+		"pit":                 map[string]interface{}{"id": pit, "keep_alive": formatKeepAlive(keepAlive)},
+		"size":                size,
+		"sort":                pointInTimePagingSort,
+		"track_total_hits":    false,
+		"_source":             fetchSource,
+		"version":             fetchSource,
+		"seq_no_primary_term": fetchSource,
+	}
+	if searchAfter != nil {
+		body["search_after"] = searchAfter
+	} else {
+		body["from"] = from
+	}
+	// The search in a point in time has no index in the path, since the point in time has it
+	return c.searchIn("/_search", body, fetchSource)
+}
+
+// formatKeepAlive returns the duration in the time units of Elasticsearch.
+func formatKeepAlive(keepAlive time.Duration) string {
+	return fmt.Sprintf("%dms", int64(keepAlive/time.Millisecond))
+}
+
+// ScrollDocuments returns a page beyond maxResultWindow, if the cluster cannot page in a point in time.
+// The IDs of the documents are scrolled through in the same order as GetPagedDocuments, and the documents in the page are searched by their IDs.
+func (c *TypelessClient) ScrollDocuments(index string, dtype string, from int, size int) (map[string]Document, error) {
+	ids, err := c.scrollIDs(index, from, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		// The page is beyond the last document
+		return make(map[string]Document), nil
+	}
+	body := map[string]interface{}{
+		"query":               map[string]interface{}{"ids": map[string]interface{}{"values": ids}},
+		"size":                len(ids),
+		"version":             true,
+		"seq_no_primary_term": true,
+	}
+	docs, _, err := c.search(index, body, true)
+	return docs, err
+}
+
+// scrollIDs returns the IDs of the documents from the offset, by scrolling through the batches of maxResultWindow documents without their sources.
+func (c *TypelessClient) scrollIDs(index string, from int, size int) ([]string, error) {
+	params := url.Values{"scroll": []string{typelessScrollKeepAlive}}
+	body := map[string]interface{}{"size": maxResultWindow, "sort": typelessPagingSort, "_source": false}
+	res, err := c.raw.PerformRequest(context.Background(), "POST", "/"+url.PathEscape(index)+"/_search", params, body)
+	if err != nil {
+		return nil, err
+	}
+	var scrollID string
+	defer func() {
+		if scrollID != "" {
+			c.raw.PerformRequest(context.Background(), "DELETE", "/_search/scroll", nil, map[string]interface{}{"scroll_id": []string{scrollID}})
+		}
+	}()
+
+	var ids []string
+	offset := 0
+	for {
+		var result struct {
+			ScrollID string `json:"_scroll_id"`
+			Hits     struct {
+				Hits []struct {
+					ID string `json:"_id"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		err = json.Unmarshal(res.Body, &result)
+		if err != nil {
+			return nil, err
+		}
+		scrollID = result.ScrollID
+		for _, hit := range result.Hits.Hits {
+			if from <= offset && offset < from+size {
+				ids = append(ids, hit.ID)
+			}
+			offset++
+		}
+		if len(result.Hits.Hits) == 0 || offset >= from+size {
+			return ids, nil
+		}
+		body = map[string]interface{}{"scroll": typelessScrollKeepAlive, "scroll_id": scrollID}
+		res, err = c.raw.PerformRequest(context.Background(), "POST", "/_search/scroll", nil, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *TypelessClient) PutMapping(index string, dtype string, mapping []byte) error {
+	_, err := c.raw.PerformRequest(context.Background(), "PUT", "/"+url.PathEscape(index)+"/_mapping", nil, json.RawMessage(mapping))
+	return err
+}
+
+func (c *TypelessClient) UpdateDocument(index string, dtype string, id string, routing string, partial []byte) (Document, error) {
+	return c.updateDocument("/"+url.PathEscape(index)+"/_update/"+url.PathEscape(id), routing, partial)
+}
+
+// typelessSearchHit is the search hit with the sequence number, which the client for Elasticsearch 5 does not know.
+type typelessSearchHit struct {
+	elastic.SearchHit
+	SeqNo       *int64 `json:"_seq_no"`
+	PrimaryTerm *int64 `json:"_primary_term"`
+}
+
+// search requests the search API directly, because the total hits are not a number any longer.
+// They are requested as a number for the compatibility, and the documents are returned with the sort values of the last one.
+func (c *TypelessClient) search(index string, body map[string]interface{}, fetchSource bool) (map[string]Document, []interface{}, error) {
+	docs, lastSort, _, err := c.searchIn("/"+url.PathEscape(index)+"/_search", body, fetchSource)
+	return docs, lastSort, err
+}
+
+// searchIn requests the search API at the path, and returns the ID of the point in time as well if the search is in it.
+func (c *TypelessClient) searchIn(path string, body map[string]interface{}, fetchSource bool) (map[string]Document, []interface{}, string, error) {
+	params := url.Values{"rest_total_hits_as_int": []string{"true"}}
+	res, err := c.raw.PerformRequest(context.Background(), "POST", path, params, body)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var result struct {
+		PitID string `json:"pit_id"`
+		Hits  struct {
+			Hits []*typelessSearchHit `json:"hits"`
+		} `json:"hits"`
+	}
+	err = json.Unmarshal(res.Body, &result)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	hits := make([]*elastic.SearchHit, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		hits[i] = &hit.SearchHit
+	}
+	docs, lastSort, err := decodeHits(hits, fetchSource)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	for _, hit := range result.Hits.Hits {
+		doc := docs[hit.Id]
+		doc.Meta.SeqNo = hit.SeqNo
+		doc.Meta.PrimaryTerm = hit.PrimaryTerm
+		docs[hit.Id] = doc
+	}
+	return docs, lastSort, result.PitID, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// The prefix of the extended attributes, which are in the user namespace to be readable by getfattr(1)
+const xattrPrefix = "user.es."
+
+func (fs *ElasticsearchFS) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	if fs.debug {
+		log.Printf("GetXAttr: name=%v, attribute=%v\n", name, attribute)
+	}
+
+	// The other namespaces such as security.* and system.posix_acl_* are asked for every file by ls -l, so they never reach Elasticsearch
+	if !strings.HasPrefix(attribute, xattrPrefix) {
+		return nil, fuse.ENODATA
+	}
+	attrs, code := fs.xattrs(name)
+	if !code.Ok() {
+		return nil, code
+	}
+	value, ok := attrs[attribute]
+	if !ok {
+		return nil, fuse.ENODATA
+	}
+	return []byte(value), fuse.OK
+}
+
+func (fs *ElasticsearchFS) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	if fs.debug {
+		log.Printf("ListXAttr: name=%v\n", name)
+	}
+
+	attrs, code := fs.xattrs(name)
+	if !code.Ok() {
+		return nil, code
+	}
+	var attributes []string
+	for attribute := range attrs {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+	return attributes, fuse.OK
+}
+
+// xattrs returns the extended attributes of the index directories, the directories of the searches and the saved queries, and the document files.
+// The other files have no attributes.
+func (fs *ElasticsearchFS) xattrs(name string) (map[string]string, fuse.Status) {
+	attrs := make(map[string]string)
+	nameElems := strings.Split(name, "/")
+
+	// The attributes of the index directory are from its settings and statistics
+	if len(nameElems) == 1 && name != "" && name != queriesDirName {
+		stats, err := fs.cache.EnsureIndexStats(nameElems[0])
+		if err != nil {
+			log.Printf("Failed to ensure the index stats: index=%v, err=%v\n", nameElems[0], err)
+			return nil, errorStatus(err)
+		}
+		attrs[xattrPrefix+"index_uuid"] = stats.UUID
+		attrs[xattrPrefix+"creation_date"] = stats.CreationDate
+		attrs[xattrPrefix+"number_of_shards"] = stats.NumberOfShards
+		attrs[xattrPrefix+"number_of_replicas"] = stats.NumberOfReplicas
+		attrs[xattrPrefix+"doc_count"] = strconv.FormatInt(stats.DocCount, 10)
+		attrs[xattrPrefix+"deleted_doc_count"] = strconv.FormatInt(stats.DeletedDocCount, 10)
+		attrs[xattrPrefix+"store_size"] = strconv.FormatInt(stats.StoreSize, 10)
+		return attrs, fuse.OK
+	}
+
+	// The attributes of the directory of a search or a saved query tell the number of the matching documents, which may be more than the pages show
+	if len(nameElems) == 3 && nameElems[1] == searchDirName {
+		s, ok := fs.searches.Get(nameElems[0], nameElems[2])
+		if ok {
+			return fs.resultXAttrs(nameElems[0], s.query)
+		}
+	}
+
+	if len(nameElems) == 2 && nameElems[0] == queriesDirName {
+		query, ok := fs.queries[nameElems[1]]
+		if ok {
+			return fs.resultXAttrs(query.Index, query.Query)
+		}
+	}
+
+	// The attributes of the document file are from the metadata of the search hit
+	if len(nameElems) == 4 && nameElems[1] != searchDirName && nameElems[0] != queriesDirName && !isMetaPath(nameElems) {
+		page, err := strconv.Atoi(nameElems[2])
+		if err != nil || page < 0 {
+			return nil, fuse.ENOENT
+		}
+		docs, err := fs.cache.EnsureDocuments(nameElems[0], nameElems[1], page)
+		if err != nil {
+			log.Printf("Failed to ensure the docs: index=%v, dtype=%v, page=%v, err=%v\n", nameElems[0], nameElems[1], page, err)
+			return nil, errorStatus(err)
+		}
+		doc, ok := fs.findDocument(docs, nameElems[3])
+		if !ok {
+			return nil, fuse.ENOENT
+		}
+		attrs[xattrPrefix+"index"] = doc.Meta.Index
+		attrs[xattrPrefix+"id"] = doc.Meta.ID
+		if doc.Meta.Type != "" {
+			attrs[xattrPrefix+"type"] = doc.Meta.Type
+		}
+		if doc.Meta.Version != nil {
+			attrs[xattrPrefix+"version"] = strconv.FormatInt(*doc.Meta.Version, 10)
+		}
+		if doc.Meta.SeqNo != nil {
+			attrs[xattrPrefix+"seq_no"] = strconv.FormatInt(*doc.Meta.SeqNo, 10)
+		}
+		if doc.Meta.PrimaryTerm != nil {
+			attrs[xattrPrefix+"primary_term"] = strconv.FormatInt(*doc.Meta.PrimaryTerm, 10)
+		}
+		if doc.Meta.Routing != "" {
+			attrs[xattrPrefix+"routing"] = doc.Meta.Routing
+		}
+	}
+	return attrs, fuse.OK
+}
+
+// resultXAttrs returns the extended attributes of the directory of the search results.
+func (fs *ElasticsearchFS) resultXAttrs(index string, query []byte) (map[string]string, fuse.Status) {
+	total, err := fs.cache.EnsureSearchTotal(index, query)
+	if err != nil {
+		log.Printf("Failed to ensure the search total: index=%v, query=%s, err=%v\n", index, query, err)
+		return nil, errorStatus(err)
+	}
+	return map[string]string{xattrPrefix + "total_hits": strconv.FormatInt(total, 10)}, fuse.OK
+}